@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/sync"
+)
+
+// runExport downloads activities from Garmin into a local folder and returns
+// the exit code. No destination is contacted and Chrome is never started.
+func runExport(args []string) int {
+	var dateFlag, fromFlag, toFlag, sinceFlag, configPath, profile, formatFlag, dir, sportsFlag, reportFormat string
+	flags := flag.NewFlagSet("garmin-to-ido export", flag.ContinueOnError)
+	flags.StringVar(&dateFlag, "d", "", "Specific date to export (format: YYYY-MM-DD). If not provided, exports today")
+	flags.StringVar(&dateFlag, "date", "", "Specific date to export (format: YYYY-MM-DD). If not provided, exports today")
+	flags.StringVar(&fromFlag, "from", "", "First date of the range to export (format: YYYY-MM-DD)")
+	flags.StringVar(&toFlag, "to", "", "Last date of the range to export (format: YYYY-MM-DD). Defaults to today")
+	flags.StringVar(&sinceFlag, "since", "", "Export a window ending today, e.g. 7d, 2w or 36h")
+	flags.StringVar(&configPath, "c", ".env", "Path to configuration file")
+	flags.StringVar(&configPath, "config", ".env", "Path to configuration file")
+	flags.StringVar(&profile, "profile", "", "Profile of the configuration file whose Garmin account is exported")
+	flags.StringVar(&formatFlag, "format", "fit", "File format: fit, gpx or tcx")
+	flags.StringVar(&dir, "dir", "export", "Directory the files are written to")
+	flags.StringVar(&sportsFlag, "sports", "all", "Comma-separated sports to export (bike, run, swim, walk, strength, other), or all")
+	flags.StringVar(&reportFormat, "report", "text", "Report format: text, or json to print a machine-readable report on stdout")
+	parseFlags(flags, args)
+	setReportFormat(reportFormat)
+
+	format, err := garmin.ParseFormat(formatFlag)
+	if err != nil {
+		fatal(exitConfigError, "Invalid -format value: %v", err)
+	}
+	sports, err := parseSports(sportsFlag)
+	if err != nil {
+		fatal(exitConfigError, "Invalid -sports value: %v", err)
+	}
+
+	// Only Garmin is used, destinations don't need to be configured
+	cfg := loadGarminConfig(configPath, profile)
+
+	startDate, endDate, err := resolveDateRange(dateFlag, fromFlag, toFlag, sinceFlag, time.Now().In(cfg.Timezone))
+	if err != nil {
+		fatal(exitConfigError, "Invalid date selection: %v", err)
+	}
+
+	fmt.Fprintf(console, "Exporting activities from %s to %s as %s\n", startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), format)
+
+	garminClient := mustLoginGarmin(cfg)
+	defer garminClient.Logout()
+
+	syncer := sync.NewSyncer(garminClient, nil, sync.Options{
+		GarminRateLimit: cfg.GarminRateLimit,
+		Location:        cfg.Timezone,
+		Retry:           retryPolicy(cfg),
+		Output:          console,
+	})
+	fmt.Fprintln(console, "\nExporting activities...")
+	report, err := syncer.Export(startDate, endDate, dir, format, sports)
+	if err != nil {
+		log.Printf("Error exporting activities: %v", err)
+	}
+	writeReport(reportFormat, report)
+
+	fmt.Fprintln(console, "\n✓ Export completed")
+	printSummary(report)
+	return exitCode(report)
+}
+
+// parseSports parses a comma-separated list of sports. "all" selects every
+// sport and yields an empty list.
+func parseSports(value string) ([]garmin.Sport, error) {
+	if strings.EqualFold(strings.TrimSpace(value), "all") {
+		return nil, nil
+	}
+
+	var sports []garmin.Sport
+	for _, name := range strings.Split(value, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		sport, err := garmin.ParseSport(name)
+		if err != nil {
+			return nil, err
+		}
+		sports = append(sports, sport)
+	}
+	return sports, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// runGarminLogin logs into Garmin Connect with the password, asking for the
+// MFA code if the account needs one, and saves the session in the token store
+// so unattended runs can use it. It returns the exit code.
+func runGarminLogin(args []string) int {
+	var configPath, profile string
+	flags := flag.NewFlagSet("garmin-to-ido garmin-login", flag.ContinueOnError)
+	flags.StringVar(&configPath, "c", ".env", "Path to configuration file")
+	flags.StringVar(&configPath, "config", ".env", "Path to configuration file")
+	flags.StringVar(&profile, "profile", "", "Profile of the configuration file whose Garmin account logs in")
+	parseFlags(flags, args)
+
+	cfg := loadGarminConfig(configPath, profile)
+	if cfg.GarminTokenDir == "" {
+		fatal(exitConfigError, "GARMIN_TOKEN_DIR is empty, the session could not be saved")
+	}
+
+	printProfile(cfg)
+	fmt.Fprintf(console, "Logging into Garmin Connect as %s\n", cfg.GarminUsername)
+	garminClient, err := loginGarmin(cfg, promptMFA)
+	if err != nil {
+		log.Printf("Failed to log into Garmin Connect: %v", err)
+		return exitAuthFailure
+	}
+	garminClient.Logout()
+
+	fmt.Fprintf(console, "✓ Garmin session saved in %s\n", cfg.GarminTokenDir)
+	return exitOK
+}
+
+// promptMFA asks for the MFA code on the terminal
+func promptMFA() (string, error) {
+	fmt.Fprint(console, "Garmin sent an MFA code, enter it: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	code := strings.TrimSpace(line)
+	if code == "" {
+		return "", fmt.Errorf("no code entered")
+	}
+	return code, nil
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"garmin-to-ido/internal/ledger"
+	"garmin-to-ido/internal/sync"
+)
+
+// Runner runs the syncs of one profile. Runs are serialized by the runner,
+// so a request waits for a running poll to finish.
+type Runner interface {
+	Sync(start, end time.Time) (*sync.Report, error)
+	RetryFailed(ids map[int64]bool) (*sync.Report, error)
+	LastReport() *sync.Report
+	// Today returns the current date in the athlete's time zone
+	Today() time.Time
+}
+
+// Profile is what the API controls for one profile
+type Profile struct {
+	Name   string
+	Runner Runner
+	Ledger *ledger.Ledger
+}
+
+// maxRangeDays bounds the range a single sync request can cover
+const maxRangeDays = 366
+
+// Server is the HTTP control API. Every request must carry the token as
+// "Authorization: Bearer <token>".
+//
+//	POST /api/sync?date=YYYY-MM-DD          sync one day (default: today)
+//	POST /api/sync?from=YYYY-MM-DD&to=...   sync a range (to defaults to today)
+//	POST /api/retry?id=123,456              retry failed uploads (default: all)
+//	GET  /api/ledger?status=failed          list ledger entries
+//	GET  /api/report                        last run report
+//
+// With several profiles, requests select one with ?profile=name.
+type Server struct {
+	token    string
+	profiles []Profile
+	mux      *http.ServeMux
+}
+
+// New creates the API for the given profiles. The token must not be empty.
+func New(token string, profiles []Profile) *Server {
+	s := &Server{token: token, profiles: profiles, mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /api/sync", s.handleSync)
+	s.mux.HandleFunc("POST /api/retry", s.handleRetry)
+	s.mux.HandleFunc("GET /api/ledger", s.handleLedger)
+	s.mux.HandleFunc("GET /api/report", s.handleReport)
+	return s
+}
+
+// ServeHTTP checks the token and dispatches the request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="garmin-to-ido"`)
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	start, end, err := dateRange(r, profile.Runner.Today())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := profile.Runner.Sync(start, end)
+	writeRun(w, report, err)
+}
+
+func (s *Server) handleRetry(w http.ResponseWriter, r *http.Request) {
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	ids := make(map[int64]bool)
+	for _, field := range strings.Split(r.URL.Query().Get("id"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid activity ID %q", field))
+			return
+		}
+		ids[id] = true
+	}
+
+	report, err := profile.Runner.RetryFailed(ids)
+	writeRun(w, report, err)
+}
+
+func (s *Server) handleLedger(w http.ResponseWriter, r *http.Request) {
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	status := ledger.Status(r.URL.Query().Get("status"))
+	entries := []ledger.Entry{}
+	for _, entry := range profile.Ledger.Entries() {
+		if status == "" || entry.Status == status {
+			entries = append(entries, entry)
+		}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	report := profile.Runner.LastReport()
+	if report == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no sync has run yet"))
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// profile returns the profile selected by the request. The profile parameter
+// can be omitted when there is only one.
+func (s *Server) profile(w http.ResponseWriter, r *http.Request) (Profile, bool) {
+	name := r.URL.Query().Get("profile")
+	if name == "" && len(s.profiles) == 1 {
+		return s.profiles[0], true
+	}
+
+	var names []string
+	for _, profile := range s.profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+		names = append(names, profile.Name)
+	}
+
+	if name == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("select a profile with ?profile= (%s)", strings.Join(names, ", ")))
+	} else {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown profile %q (%s)", name, strings.Join(names, ", ")))
+	}
+	return Profile{}, false
+}
+
+// dateRange reads the date selection of a sync request
+func dateRange(r *http.Request, today time.Time) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	date, from, to := query.Get("date"), query.Get("from"), query.Get("to")
+
+	if date != "" {
+		if from != "" || to != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("date cannot be combined with from or to")
+		}
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid date, use YYYY-MM-DD")
+		}
+		return day, day, nil
+	}
+
+	end := today
+	if to != "" {
+		day, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date, use YYYY-MM-DD")
+		}
+		end = day
+	}
+
+	start := end
+	if from != "" {
+		day, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date, use YYYY-MM-DD")
+		}
+		start = day
+	}
+
+	if start.After(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from is after to")
+	}
+	if end.Sub(start) > maxRangeDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("range is longer than %d days", maxRangeDays)
+	}
+	return start, end, nil
+}
+
+// writeRun answers a sync or retry request. The report is returned even when
+// the run failed; without one, the sessions could not be restored.
+func writeRun(w http.ResponseWriter, report *sync.Report, err error) {
+	if report == nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"garmin-to-ido/internal/ledger"
+	"garmin-to-ido/internal/sync"
+)
+
+const testToken = "s3cret-token"
+
+// fakeRunner records the runs it is asked for
+type fakeRunner struct {
+	syncs   [][2]time.Time
+	retries []map[int64]bool
+	last    *sync.Report
+	err     error
+}
+
+func (r *fakeRunner) Sync(start, end time.Time) (*sync.Report, error) {
+	r.syncs = append(r.syncs, [2]time.Time{start, end})
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &sync.Report{From: start.Format("2006-01-02"), To: end.Format("2006-01-02"), Synced: 1}, nil
+}
+
+func (r *fakeRunner) RetryFailed(ids map[int64]bool) (*sync.Report, error) {
+	r.retries = append(r.retries, ids)
+	return &sync.Report{Failed: 1, ErrorClass: sync.ErrorUpload}, errors.New("1 upload failed")
+}
+
+func (r *fakeRunner) LastReport() *sync.Report { return r.last }
+
+func (r *fakeRunner) Today() time.Time { return time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC) }
+
+// request sends a request to the API with the given token, empty for none
+func request(handler http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAuthentication(t *testing.T) {
+	runner := &fakeRunner{}
+	server := New(testToken, []Profile{{Runner: runner}})
+
+	for _, test := range []struct {
+		name   string
+		header string
+	}{
+		{"missing token", ""},
+		{"wrong token", "Bearer not-the-token"},
+		{"token prefix", "Bearer s3cret"},
+		{"other scheme", "Basic " + testToken},
+		{"bare token", testToken},
+	} {
+		req := httptest.NewRequest("POST", "/api/sync", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", test.name, recorder.Code)
+		}
+		if recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate header", test.name)
+		}
+	}
+	if len(runner.syncs) != 0 {
+		t.Errorf("unauthenticated requests ran %d syncs", len(runner.syncs))
+	}
+
+	// An API without token rejects everything
+	if code := request(New("", []Profile{{Runner: runner}}), "POST", "/api/sync", "").Code; code != http.StatusUnauthorized {
+		t.Errorf("API without token: status %d, want 401", code)
+	}
+}
+
+func TestMethodRouting(t *testing.T) {
+	server := New(testToken, []Profile{{Runner: &fakeRunner{}}})
+	for _, test := range []struct {
+		method, target string
+		want           int
+	}{
+		{"GET", "/api/sync", http.StatusMethodNotAllowed},
+		{"GET", "/api/retry", http.StatusMethodNotAllowed},
+		{"POST", "/api/ledger", http.StatusMethodNotAllowed},
+		{"DELETE", "/api/report", http.StatusMethodNotAllowed},
+		{"GET", "/api/unknown", http.StatusNotFound},
+	} {
+		if code := request(server, test.method, test.target, testToken).Code; code != test.want {
+			t.Errorf("%s %s: status %d, want %d", test.method, test.target, code, test.want)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	day := func(value string) time.Time {
+		date, _ := time.Parse("2006-01-02", value)
+		return date
+	}
+
+	for _, test := range []struct {
+		query              string
+		wantStart, wantEnd string
+		wantStatus         int
+	}{
+		{"", "2025-01-15", "2025-01-15", http.StatusOK},
+		{"?date=2025-01-01", "2025-01-01", "2025-01-01", http.StatusOK},
+		{"?from=2025-01-10", "2025-01-10", "2025-01-15", http.StatusOK},
+		{"?from=2024-12-01&to=2024-12-31", "2024-12-01", "2024-12-31", http.StatusOK},
+		{"?date=2025-01-01&from=2025-01-01", "", "", http.StatusBadRequest},
+		{"?date=01/01/2025", "", "", http.StatusBadRequest},
+		{"?from=2025-01-10&to=2025-01-01", "", "", http.StatusBadRequest},
+		{"?from=2020-01-01", "", "", http.StatusBadRequest},
+	} {
+		runner := &fakeRunner{}
+		recorder := request(New(testToken, []Profile{{Runner: runner}}), "POST", "/api/sync"+test.query, testToken)
+		if recorder.Code != test.wantStatus {
+			t.Errorf("sync%s: status %d, want %d: %s", test.query, recorder.Code, test.wantStatus, recorder.Body)
+			continue
+		}
+		if test.wantStatus != http.StatusOK {
+			if len(runner.syncs) != 0 {
+				t.Errorf("sync%s: a rejected request ran a sync", test.query)
+			}
+			continue
+		}
+		if len(runner.syncs) != 1 || !runner.syncs[0][0].Equal(day(test.wantStart)) || !runner.syncs[0][1].Equal(day(test.wantEnd)) {
+			t.Errorf("sync%s: ran %v, want %s to %s", test.query, runner.syncs, test.wantStart, test.wantEnd)
+		}
+
+		var report sync.Report
+		if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatalf("sync%s: invalid report: %v", test.query, err)
+		}
+		if report.From != test.wantStart || report.To != test.wantEnd || report.Synced != 1 {
+			t.Errorf("sync%s: report %+v", test.query, report)
+		}
+	}
+
+	// Without a report, the sessions could not be restored
+	runner := &fakeRunner{err: errors.New("failed to restore Garmin session")}
+	recorder := request(New(testToken, []Profile{{Runner: runner}}), "POST", "/api/sync", testToken)
+	if recorder.Code != http.StatusBadGateway || !strings.Contains(recorder.Body.String(), "failed to restore Garmin session") {
+		t.Errorf("failed sync: status %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	runner := &fakeRunner{}
+	server := New(testToken, []Profile{{Runner: runner}})
+
+	// A run with failures still answers its report
+	recorder := request(server, "POST", "/api/retry?id=12,%2034", testToken)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"errorClass": "upload"`) {
+		t.Errorf("retry: status %d: %s", recorder.Code, recorder.Body)
+	}
+	if len(runner.retries) != 1 || len(runner.retries[0]) != 2 || !runner.retries[0][12] || !runner.retries[0][34] {
+		t.Errorf("retried %v, want 12 and 34", runner.retries)
+	}
+
+	request(server, "POST", "/api/retry", testToken)
+	if len(runner.retries) != 2 || len(runner.retries[1]) != 0 {
+		t.Errorf("retry without ids: %v, want all failed uploads", runner.retries)
+	}
+
+	if code := request(server, "POST", "/api/retry?id=12,abc", testToken).Code; code != http.StatusBadRequest {
+		t.Errorf("retry with an invalid id: status %d, want 400", code)
+	}
+}
+
+func TestLedgerAndReport(t *testing.T) {
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.MarkUploaded(ledger.Entry{ActivityID: 1})
+	l.MarkFailed(ledger.Entry{ActivityID: 2}, errors.New("HTTP 500"))
+
+	runner := &fakeRunner{}
+	server := New(testToken, []Profile{{Runner: runner, Ledger: l}})
+
+	for _, test := range []struct {
+		query string
+		want  []int64
+	}{
+		{"", []int64{1, 2}},
+		{"?status=failed", []int64{2}},
+		{"?status=uploaded", []int64{1}},
+		{"?status=unknown", []int64{}},
+	} {
+		recorder := request(server, "GET", "/api/ledger"+test.query, testToken)
+		var entries []ledger.Entry
+		if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil || entries == nil {
+			t.Errorf("ledger%s: %v: %s", test.query, err, recorder.Body)
+			continue
+		}
+		var ids []int64
+		for _, entry := range entries {
+			ids = append(ids, entry.ActivityID)
+		}
+		if len(ids) != len(test.want) || (len(ids) > 0 && ids[0] != test.want[0]) {
+			t.Errorf("ledger%s: entries %v, want %v", test.query, ids, test.want)
+		}
+	}
+
+	if code := request(server, "GET", "/api/report", testToken).Code; code != http.StatusNotFound {
+		t.Errorf("report before any run: status %d, want 404", code)
+	}
+	runner.last = &sync.Report{Synced: 3}
+	recorder := request(server, "GET", "/api/report", testToken)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"synced": 3`) {
+		t.Errorf("report: status %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestProfileSelection(t *testing.T) {
+	alice, bob := &fakeRunner{}, &fakeRunner{}
+	server := New(testToken, []Profile{{Name: "alice", Runner: alice}, {Name: "bob", Runner: bob}})
+
+	if code := request(server, "POST", "/api/sync?profile=bob", testToken).Code; code != http.StatusOK {
+		t.Errorf("sync of bob: status %d", code)
+	}
+	if len(alice.syncs) != 0 || len(bob.syncs) != 1 {
+		t.Errorf("syncs: alice %d, bob %d, want bob only", len(alice.syncs), len(bob.syncs))
+	}
+
+	recorder := request(server, "POST", "/api/sync", testToken)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "alice, bob") {
+		t.Errorf("sync without profile: status %d: %s", recorder.Code, recorder.Body)
+	}
+	if code := request(server, "POST", "/api/sync?profile=carol", testToken).Code; code != http.StatusNotFound {
+		t.Errorf("sync of an unknown profile: status %d, want 404", code)
+	}
+}
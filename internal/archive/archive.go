@@ -0,0 +1,382 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultNameTemplate files activities by month, e.g.
+// 2025-01/20250115_071500_12345678901_road_biking
+const DefaultNameTemplate = `{{.StartTime.Format "2006-01"}}/{{.StartTime.Format "20060102_150405"}}_{{.ActivityID}}_{{.ActivityType}}`
+
+// indexFile is the name of the index kept at the root of the archive
+const indexFile = "index.json"
+
+// Options configures an archive
+type Options struct {
+	// Dir is the root directory of the archive
+	Dir string
+	// NameTemplate is a text/template rendering the path of an activity,
+	// relative to Dir and without extension
+	NameTemplate string
+
+	// Retention policy, applied by Prune. Zero disables a limit.
+	MaxAge   time.Duration
+	MaxCount int
+	MaxSize  int64 // bytes
+}
+
+// Activity describes an activity to archive
+type Activity struct {
+	ActivityID   int64
+	ActivityName string
+	ActivityType string
+	StartTime    time.Time
+	FitData      []byte
+	// ZipData is the original download, archived next to the FIT file if set
+	ZipData []byte
+}
+
+// Record is an archived activity, as listed in the index
+type Record struct {
+	Hash         string    `json:"hash"` // SHA-256 of the FIT file
+	ActivityID   int64     `json:"activityId"`
+	ActivityName string    `json:"activityName,omitempty"`
+	ActivityType string    `json:"activityType,omitempty"`
+	StartTime    time.Time `json:"startTime"`
+	FitFile      string    `json:"fitFile"` // relative to the archive root
+	ZipFile      string    `json:"zipFile,omitempty"`
+	Size         int64     `json:"size"` // bytes, FIT and ZIP files together
+	ArchivedAt   time.Time `json:"archivedAt"`
+}
+
+// Archive stores downloaded activity files under a root directory, keyed by
+// the hash of their content so the same file is never stored twice
+type Archive struct {
+	opts    Options
+	tmpl    *template.Template
+	mu      sync.Mutex
+	records map[string]*Record
+
+	// saveMu serializes writes to the index file
+	saveMu sync.Mutex
+}
+
+// Open loads the archive rooted at opts.Dir. A missing index yields an
+// empty archive.
+func Open(opts Options) (*Archive, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("archive directory is not set")
+	}
+	if opts.NameTemplate == "" {
+		opts.NameTemplate = DefaultNameTemplate
+	}
+
+	tmpl, err := template.New("archive").Option("missingkey=error").Parse(opts.NameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive name template: %w", err)
+	}
+
+	a := &Archive{
+		opts:    opts,
+		tmpl:    tmpl,
+		records: make(map[string]*Record),
+	}
+
+	data, err := os.ReadFile(filepath.Join(opts.Dir, indexFile))
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive index: %w", err)
+	}
+
+	var records []*Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse archive index: %w", err)
+	}
+	for _, record := range records {
+		a.records[record.Hash] = record
+	}
+
+	return a, nil
+}
+
+// Hash returns the content hash used to identify a FIT file
+func Hash(fitData []byte) string {
+	sum := sha256.Sum256(fitData)
+	return hex.EncodeToString(sum[:])
+}
+
+// Store archives the activity files and saves the index. If a file with the
+// same content is already archived, nothing is written and stored is false.
+// A different file whose name renders the same, e.g. an activity edited on
+// Garmin since, gets the content hash appended to its name.
+func (a *Archive) Store(activity Activity) (record Record, stored bool, err error) {
+	hash := Hash(activity.FitData)
+
+	a.mu.Lock()
+	existing, ok := a.records[hash]
+	a.mu.Unlock()
+	if ok {
+		if _, err := os.Stat(a.Path(existing.FitFile)); err == nil {
+			return *existing, false, nil
+		}
+	}
+
+	name, err := a.name(activity)
+	if err != nil {
+		return Record{}, false, err
+	}
+	a.mu.Lock()
+	if a.usedByOther(name+".fit", hash) || a.usedByOther(name+".zip", hash) {
+		name += "_" + shortHash(hash)
+	}
+	a.mu.Unlock()
+
+	record = Record{
+		Hash:         hash,
+		ActivityID:   activity.ActivityID,
+		ActivityName: activity.ActivityName,
+		ActivityType: activity.ActivityType,
+		StartTime:    activity.StartTime,
+		FitFile:      name + ".fit",
+		Size:         int64(len(activity.FitData)),
+		ArchivedAt:   time.Now().UTC(),
+	}
+	if err := a.write(record.FitFile, activity.FitData); err != nil {
+		return Record{}, false, err
+	}
+	if len(activity.ZipData) > 0 {
+		record.ZipFile = name + ".zip"
+		record.Size += int64(len(activity.ZipData))
+		if err := a.write(record.ZipFile, activity.ZipData); err != nil {
+			return Record{}, false, err
+		}
+	}
+
+	a.mu.Lock()
+	a.records[hash] = &record
+	a.mu.Unlock()
+
+	return record, true, a.save()
+}
+
+// Get returns the record of an archived FIT file by content hash
+func (a *Archive) Get(hash string) (Record, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	record, ok := a.records[hash]
+	if !ok {
+		return Record{}, false
+	}
+	return *record, true
+}
+
+// Read returns the archived FIT file with the given content hash
+func (a *Archive) Read(hash string) ([]byte, error) {
+	record, ok := a.Get(hash)
+	if !ok {
+		return nil, fmt.Errorf("FIT file %s is not archived", shortHash(hash))
+	}
+	data, err := os.ReadFile(a.Path(record.FitFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archived FIT file: %w", err)
+	}
+	return data, nil
+}
+
+// Path returns the absolute location of a file of the archive
+func (a *Archive) Path(file string) string {
+	return filepath.Join(a.opts.Dir, filepath.FromSlash(file))
+}
+
+// Prune applies the retention policy, removing the oldest records first,
+// and returns the removed records. Records whose hash is in keep (e.g. FIT
+// files still needed to retry a failed upload) are never removed.
+func (a *Archive) Prune(keep map[string]bool) ([]Record, error) {
+	a.mu.Lock()
+	records := make([]*Record, 0, len(a.records))
+	var totalSize int64
+	for _, record := range a.records {
+		records = append(records, record)
+		totalSize += record.Size
+	}
+	a.mu.Unlock()
+
+	// Newest first, so the records over the count limit are at the end
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ArchivedAt.After(records[j].ArchivedAt)
+	})
+
+	now := time.Now()
+	count := len(records)
+	var removed []Record
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		expired := a.opts.MaxAge > 0 && now.Sub(record.ArchivedAt) > a.opts.MaxAge
+		tooMany := a.opts.MaxCount > 0 && count > a.opts.MaxCount
+		tooBig := a.opts.MaxSize > 0 && totalSize > a.opts.MaxSize
+		if !expired && !tooMany && !tooBig {
+			continue
+		}
+		if keep[record.Hash] {
+			continue
+		}
+
+		if err := a.remove(record); err != nil {
+			return removed, err
+		}
+		count--
+		totalSize -= record.Size
+		removed = append(removed, *record)
+	}
+
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	return removed, a.save()
+}
+
+// name renders the name template for an activity
+func (a *Archive) name(activity Activity) (string, error) {
+	data := struct {
+		ActivityID   int64
+		ActivityName string
+		ActivityType string
+		StartTime    time.Time
+	}{
+		ActivityID:   activity.ActivityID,
+		ActivityName: safeName(activity.ActivityName),
+		ActivityType: safeName(activity.ActivityType),
+		StartTime:    activity.StartTime,
+	}
+
+	var buf bytes.Buffer
+	if err := a.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render archive name: %w", err)
+	}
+
+	name := filepath.ToSlash(filepath.Clean(strings.TrimSpace(buf.String())))
+	if name == "." || filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("archive name template rendered an invalid path %q", buf.String())
+	}
+	return name, nil
+}
+
+// write writes a file of the archive, creating its directory if needed
+func (a *Archive) write(file string, data []byte) error {
+	path := a.Path(file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", file, err)
+	}
+	return nil
+}
+
+// remove deletes the files of a record and drops it from the index. Files
+// another record also points to, as indexes written before names were made
+// unique may hold, are left in place.
+func (a *Archive) remove(record *Record) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, file := range []string{record.FitFile, record.ZipFile} {
+		if file == "" || a.usedByOther(file, record.Hash) {
+			continue
+		}
+		if err := os.Remove(a.Path(file)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", file, err)
+		}
+	}
+
+	delete(a.records, record.Hash)
+	return nil
+}
+
+// usedByOther reports whether a record other than the one with the given
+// hash points to file. The caller must hold mu.
+func (a *Archive) usedByOther(file, hash string) bool {
+	for _, record := range a.records {
+		if record.Hash != hash && (record.FitFile == file || record.ZipFile == file) {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the index atomically
+func (a *Archive) save() error {
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+
+	a.mu.Lock()
+	records := make([]*Record, 0, len(a.records))
+	for _, record := range a.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].FitFile < records[j].FitFile
+	})
+	data, err := json.MarshalIndent(records, "", "  ")
+	a.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode archive index: %w", err)
+	}
+
+	if err := os.MkdirAll(a.opts.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(a.opts.Dir, ".index_*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temp archive index: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write archive index: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write archive index: %w", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), filepath.Join(a.opts.Dir, indexFile)); err != nil {
+		return fmt.Errorf("failed to replace archive index: %w", err)
+	}
+
+	return nil
+}
+
+// safeName replaces the characters that cannot appear in a file name
+func safeName(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// shortHash abbreviates a content hash for messages
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
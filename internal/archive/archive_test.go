@@ -0,0 +1,217 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ride is an activity whose FIT content is data
+func ride(data string) Activity {
+	return Activity{
+		ActivityID:   12345678901,
+		ActivityName: "Morning: Ride",
+		ActivityType: "road_biking",
+		StartTime:    time.Date(2025, 1, 15, 7, 15, 0, 0, time.UTC),
+		FitData:      []byte(data),
+	}
+}
+
+func TestStoreAndRead(t *testing.T) {
+	dir := t.TempDir()
+	a, err := Open(Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("Open of a missing index: %v", err)
+	}
+
+	activity := ride("fit data")
+	activity.ZipData = []byte("zip data")
+	record, stored, err := a.Store(activity)
+	if err != nil || !stored {
+		t.Fatalf("Store = %v, %v", stored, err)
+	}
+	if record.FitFile != "2025-01/20250115_071500_12345678901_road_biking.fit" {
+		t.Errorf("FitFile = %q", record.FitFile)
+	}
+	if record.ZipFile != "2025-01/20250115_071500_12345678901_road_biking.zip" {
+		t.Errorf("ZipFile = %q", record.ZipFile)
+	}
+	if record.Size != int64(len("fit data")+len("zip data")) {
+		t.Errorf("Size = %d", record.Size)
+	}
+
+	// The same content is not stored twice
+	if again, stored, err := a.Store(ride("fit data")); err != nil || stored || again.FitFile != record.FitFile {
+		t.Errorf("Store of the same content = %+v, %v, %v", again, stored, err)
+	}
+
+	// The index is read back by the next Open
+	reopened, err := Open(Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, err := reopened.Read(record.Hash)
+	if err != nil || string(data) != "fit data" {
+		t.Errorf("Read = %q, %v", data, err)
+	}
+	if _, err := reopened.Read(Hash([]byte("other"))); err == nil {
+		t.Error("Read of a file that is not archived succeeded")
+	}
+
+	// A missing file is archived again
+	if err := os.Remove(reopened.Path(record.FitFile)); err != nil {
+		t.Fatal(err)
+	}
+	if _, stored, err := reopened.Store(ride("fit data")); err != nil || !stored {
+		t.Errorf("Store of a missing file = %v, %v", stored, err)
+	}
+}
+
+func TestStoreNameTemplate(t *testing.T) {
+	a, err := Open(Options{Dir: t.TempDir(), NameTemplate: "{{.ActivityType}}/{{.ActivityName}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _, err := a.Store(ride("fit data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.FitFile != "road_biking/Morning_ Ride.fit" {
+		t.Errorf("FitFile = %q", record.FitFile)
+	}
+
+	escaping, err := Open(Options{Dir: t.TempDir(), NameTemplate: "../{{.ActivityID}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := escaping.Store(ride("fit data")); err == nil {
+		t.Error("a name outside the archive was accepted")
+	}
+}
+
+func TestStoreNameCollision(t *testing.T) {
+	a, err := Open(Options{Dir: t.TempDir(), MaxCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The activity was edited on Garmin and downloaded again
+	older, _, err := a.Store(ride("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, stored, err := a.Store(ride("edited"))
+	if err != nil || !stored {
+		t.Fatalf("Store of the edited activity = %v, %v", stored, err)
+	}
+	if newer.FitFile == older.FitFile {
+		t.Fatalf("both versions are archived as %s", newer.FitFile)
+	}
+	if !strings.HasSuffix(newer.FitFile, "_"+shortHash(newer.Hash)+".fit") {
+		t.Errorf("FitFile = %q, want the hash appended", newer.FitFile)
+	}
+
+	a.records[older.Hash].ArchivedAt = older.ArchivedAt.Add(-time.Hour)
+	removed, err := a.Prune(nil)
+	if err != nil || len(removed) != 1 || removed[0].Hash != older.Hash {
+		t.Fatalf("Prune = %+v, %v, want the older version removed", removed, err)
+	}
+	if data, err := a.Read(newer.Hash); err != nil || string(data) != "edited" {
+		t.Errorf("Read of the newer version = %q, %v", data, err)
+	}
+}
+
+func TestPruneKeepsSharedFiles(t *testing.T) {
+	a, err := Open(Options{Dir: t.TempDir(), MaxCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, _, err := a.Store(ride("edited"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An index written before names were made unique
+	stale := record
+	stale.Hash = Hash([]byte("original"))
+	stale.ArchivedAt = record.ArchivedAt.Add(-time.Hour)
+	a.records[stale.Hash] = &stale
+
+	if _, err := a.Prune(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Get(stale.Hash); ok {
+		t.Error("the stale record was kept")
+	}
+	if data, err := a.Read(record.Hash); err != nil || string(data) != "edited" {
+		t.Errorf("Read of the remaining record = %q, %v", data, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		name string
+		opts Options
+		keep []string
+		want []string // removed records, oldest first
+	}{
+		{"no limits", Options{}, nil, nil},
+		{"max age", Options{MaxAge: 36 * time.Hour}, nil, []string{"a", "b"}},
+		{"max count", Options{MaxCount: 2}, nil, []string{"a", "b"}},
+		{"max size", Options{MaxSize: 25}, nil, []string{"a", "b"}},
+		{"max size met", Options{MaxSize: 40}, nil, nil},
+		{"kept for retry", Options{MaxCount: 2}, []string{"a"}, []string{"b", "c"}},
+		{"all kept", Options{MaxAge: time.Minute}, []string{"a", "b", "c", "d"}, nil},
+	} {
+		opts := test.opts
+		opts.Dir = t.TempDir()
+		a, err := Open(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Four files of 10 bytes, archived a, b, c, d from 3 days ago to now
+		hashes := make(map[string]string)
+		for i, label := range []string{"a", "b", "c", "d"} {
+			activity := ride(strings.Repeat(label, 10))
+			activity.ActivityID = int64(i)
+			record, _, err := a.Store(activity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.records[record.Hash].ArchivedAt = now.Add(time.Duration(i-3) * 24 * time.Hour)
+			hashes[record.Hash] = label
+		}
+		keep := make(map[string]bool)
+		for _, label := range test.keep {
+			keep[Hash(bytes.Repeat([]byte(label), 10))] = true
+		}
+
+		removed, err := a.Prune(keep)
+		if err != nil {
+			t.Fatalf("%s: Prune: %v", test.name, err)
+		}
+		var got []string
+		for _, record := range removed {
+			got = append(got, hashes[record.Hash])
+			if _, err := os.Stat(a.Path(record.FitFile)); !os.IsNotExist(err) {
+				t.Errorf("%s: %s was not deleted", test.name, record.FitFile)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: removed %v, want %v", test.name, got, test.want)
+		}
+
+		// The index no longer lists them
+		reopened, err := Open(Options{Dir: opts.Dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reopened.records) != 4-len(test.want) {
+			t.Errorf("%s: the index lists %d records, want %d", test.name, len(reopened.records), 4-len(test.want))
+		}
+	}
+}
@@ -4,27 +4,206 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"garmin-to-ido/internal/daemon"
+	"garmin-to-ido/internal/filter"
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/notify"
 )
 
 // Config holds the application configuration
 type Config struct {
+	// Profile is the name of the profile section the config was loaded
+	// from, empty for a config file without profiles
+	Profile string
+
 	GarminUsername string
 	GarminPassword string
 	IdoUsername    string
 	IdoPassword    string
+
+	// GarminClient selects the Garmin Connect client: "python" runs the
+	// garminconnect library, "native" talks to Garmin directly
+	GarminClient string
+	// GarminTokenDir keeps the Garmin session between runs. Empty disables it.
+	GarminTokenDir string
+	// GarminConsumerKey and GarminConsumerSecret replace the OAuth1 consumer
+	// of the native client. Empty uses the mobile app's.
+	GarminConsumerKey    string
+	GarminConsumerSecret string
+
+	// DataDir is the base directory of relative paths (ledger, archive,
+	// folder destination). Defaults to the directory of the config file.
+	DataDir    string
+	LedgerPath string
+
+	// Archive settings for downloaded files
+	ArchiveEnabled      bool
+	ArchiveDir          string
+	ArchiveNameTemplate string
+	ArchiveMaxAge       time.Duration // 0 = keep forever
+	ArchiveMaxCount     int           // 0 = unlimited
+	ArchiveMaxSize      int64         // bytes, 0 = unlimited
+
+	// Destinations lists where activities are uploaded ("ido", "folder")
+	Destinations         []string
+	FolderDestinationDir string
+
+	// Sports is the whitelist of sports synced (bike, run, swim, walk, strength, other)
+	Sports []garmin.Sport
+
+	// Timezone is the athlete's time zone. It decides which day an activity
+	// belongs to, for date selection and for the date shown in iDO.
+	Timezone *time.Location
+
+	// Filter selects which activities are synced
+	Filter filter.Options
+
+	// NameTemplate names the uploaded activities (Go text/template) and
+	// SportNameTemplates overrides it per sport. Empty uses the built-in
+	// template of each sport.
+	NameTemplate       string
+	SportNameTemplates map[garmin.Sport]string
+
+	// Pipeline settings
+	DownloadConcurrency int
+	UploadConcurrency   int
+	GarminRateLimit     int // requests per minute, 0 = unlimited
+	UploadRateLimit     int // uploads per minute and destination, 0 = unlimited
+
+	// Retry settings for transient failures
+	RetryMaxAttempts  int
+	RetryInitialDelay time.Duration
+	RetryMaxDelay     time.Duration
+
+	// Notify configures the notifications sent after each sync run
+	Notify notify.Options
+
+	// Daemon mode settings
+	PollInterval     time.Duration
+	PollLookbackDays int
+	QuietHours       string
+	// MetricsAddr is the listen address of the Prometheus /metrics endpoint,
+	// e.g. ":9101". Empty disables it.
+	MetricsAddr string
+	// APIAddr is the listen address of the HTTP control API, protected by
+	// APIToken. Empty disables it.
+	APIAddr  string
+	APIToken string
+}
+
+const (
+	defaultLedgerPath          = "ledger.json"
+	defaultGarminTokenDir      = "garmin_tokens"
+	defaultArchiveDir          = "downloaded_fits"
+	defaultDownloadConcurrency = 2
+	defaultUploadConcurrency   = 1
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialDelay   = 2 * time.Second
+	defaultRetryMaxDelay       = time.Minute
+	defaultPollInterval        = 30 * time.Minute
+	defaultPollLookbackDays    = 1
+)
+
+// Profile names may only use these characters, as they are used in paths
+var profileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// setting is one KEY=VALUE line of the config file
+type setting struct {
+	key   string
+	value string
 }
 
-// Load reads configuration from a file
+// file is a parsed config file: shared settings, then [name] profile sections
+// overriding them
+type file struct {
+	shared   []setting
+	profiles map[string][]setting
+	order    []string
+}
+
+// Load reads configuration from a file, ignoring profile sections
 func Load(path string) (*Config, error) {
-	file, err := os.Open(path)
+	return LoadProfile(path, "")
+}
+
+// LoadProfile reads configuration from a file, with the settings of a profile
+// section applied over the shared ones. Relative paths of a profile are
+// resolved under DATA_DIR/profiles/<name>, so each profile has its own ledger
+// and archive.
+func LoadProfile(path, profile string) (*Config, error) {
+	f, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := f.shared
+	if profile != "" {
+		profileSettings, ok := f.profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", profile)
+		}
+		settings = append(slices.Clone(f.shared), profileSettings...)
+	}
+
+	cfg := &Config{
+		Profile:             profile,
+		GarminClient:        "python",
+		GarminTokenDir:      defaultGarminTokenDir,
+		LedgerPath:          defaultLedgerPath,
+		ArchiveEnabled:      true,
+		ArchiveDir:          defaultArchiveDir,
+		Destinations:        []string{"ido"},
+		Sports:              []garmin.Sport{garmin.SportBike},
+		Timezone:            time.Local,
+		DownloadConcurrency: defaultDownloadConcurrency,
+		UploadConcurrency:   defaultUploadConcurrency,
+		RetryMaxAttempts:    defaultRetryMaxAttempts,
+		RetryInitialDelay:   defaultRetryInitialDelay,
+		RetryMaxDelay:       defaultRetryMaxDelay,
+		PollInterval:        defaultPollInterval,
+		PollLookbackDays:    defaultPollLookbackDays,
+	}
+	for _, setting := range settings {
+		if err := cfg.set(setting.key, setting.value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.resolvePaths(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Profiles returns the names of the profiles defined in a config file, in
+// the order of the file
+func Profiles(path string) ([]string, error) {
+	f, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.order, nil
+}
+
+// readFile parses a config file into shared and per-profile settings
+func readFile(path string) (*file, error) {
+	osFile, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open config file: %w", err)
 	}
-	defer file.Close()
+	defer osFile.Close()
 
-	cfg := &Config{}
-	scanner := bufio.NewScanner(file)
+	f := &file{profiles: make(map[string][]setting)}
+	scanner := bufio.NewScanner(osFile)
+	current := ""
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -34,6 +213,20 @@ func Load(path string) (*Config, error) {
 			continue
 		}
 
+		// [name] starts a profile section
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			if !profileName.MatchString(current) {
+				return nil, fmt.Errorf("invalid profile name %q (use letters, digits, - and _)", current)
+			}
+			if _, ok := f.profiles[current]; ok {
+				return nil, fmt.Errorf("profile %q is defined twice", current)
+			}
+			f.profiles[current] = nil
+			f.order = append(f.order, current)
+			continue
+		}
+
 		// Parse KEY=VALUE format
 		parts := strings.SplitN(line, "=", 2)
 		if len(parts) != 2 {
@@ -46,15 +239,10 @@ func Load(path string) (*Config, error) {
 		// Remove quotes if present
 		value = strings.Trim(value, "\"'")
 
-		switch key {
-		case "GARMIN_USERNAME":
-			cfg.GarminUsername = value
-		case "GARMIN_PASSWORD":
-			cfg.GarminPassword = value
-		case "IDO_USERNAME":
-			cfg.IdoUsername = value
-		case "IDO_PASSWORD":
-			cfg.IdoPassword = value
+		if current == "" {
+			f.shared = append(f.shared, setting{key, value})
+		} else {
+			f.profiles[current] = append(f.profiles[current], setting{key, value})
 		}
 	}
 
@@ -62,22 +250,359 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
 
-	return cfg, nil
+	return f, nil
 }
 
-// Validate checks if all required configuration values are present
-func (c *Config) Validate() error {
+// set applies one KEY=VALUE setting. Unknown keys are ignored.
+func (c *Config) set(key, value string) error {
+	var err error
+	switch key {
+	case "GARMIN_USERNAME":
+		c.GarminUsername = value
+	case "GARMIN_PASSWORD":
+		c.GarminPassword = value
+	case "GARMIN_CLIENT":
+		c.GarminClient = strings.ToLower(value)
+	case "GARMIN_TOKEN_DIR":
+		c.GarminTokenDir = value
+	case "GARMIN_CONSUMER_KEY":
+		c.GarminConsumerKey = value
+	case "GARMIN_CONSUMER_SECRET":
+		c.GarminConsumerSecret = value
+	case "IDO_USERNAME":
+		c.IdoUsername = value
+	case "IDO_PASSWORD":
+		c.IdoPassword = value
+	case "DATA_DIR":
+		c.DataDir = value
+	case "LEDGER_PATH":
+		c.LedgerPath = value
+	case "ARCHIVE_ENABLED":
+		if c.ArchiveEnabled, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case "ARCHIVE_DIR":
+		c.ArchiveDir = value
+	case "ARCHIVE_NAME_TEMPLATE":
+		c.ArchiveNameTemplate = value
+	case "ARCHIVE_MAX_AGE":
+		if c.ArchiveMaxAge, err = parseAge(key, value); err != nil {
+			return err
+		}
+	case "ARCHIVE_MAX_COUNT":
+		if c.ArchiveMaxCount, err = parseInt(key, value); err != nil {
+			return err
+		}
+	case "ARCHIVE_MAX_SIZE":
+		if c.ArchiveMaxSize, err = parseSize(key, value); err != nil {
+			return err
+		}
+	case "FILTER_INCLUDE_TYPES":
+		c.Filter.IncludeTypes = splitList(value)
+	case "FILTER_EXCLUDE_TYPES":
+		c.Filter.ExcludeTypes = splitList(value)
+	case "FILTER_MIN_DURATION":
+		if c.Filter.MinDuration, err = parseDuration(key, value); err != nil {
+			return err
+		}
+	case "FILTER_MIN_DISTANCE_KM":
+		if c.Filter.MinDistanceKm, err = strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case "FILTER_NAME_REGEX":
+		c.Filter.NameRegex = value
+	case "FILTER_EXCLUDE_NAME_REGEX":
+		c.Filter.ExcludeNameRegex = value
+	case "FILTER_ENVIRONMENT":
+		c.Filter.Environment = value
+	case "SPORTS":
+		c.Sports = nil
+		for _, name := range splitList(value) {
+			sport, err := garmin.ParseSport(name)
+			if err != nil {
+				return fmt.Errorf("invalid SPORTS: %w", err)
+			}
+			c.Sports = append(c.Sports, sport)
+		}
+	case "ATHLETE_TIMEZONE":
+		if value == "" {
+			c.Timezone = time.Local
+		} else if c.Timezone, err = time.LoadLocation(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case "DESTINATIONS":
+		c.Destinations = splitList(value)
+	case "FOLDER_DESTINATION_DIR":
+		c.FolderDestinationDir = value
+	case "POLL_INTERVAL":
+		if c.PollInterval, err = parseDuration(key, value); err != nil {
+			return err
+		}
+	case "POLL_LOOKBACK_DAYS":
+		if c.PollLookbackDays, err = parseInt(key, value); err != nil {
+			return err
+		}
+	case "DOWNLOAD_CONCURRENCY":
+		if c.DownloadConcurrency, err = parseInt(key, value); err != nil {
+			return err
+		}
+	case "UPLOAD_CONCURRENCY":
+		if c.UploadConcurrency, err = parseInt(key, value); err != nil {
+			return err
+		}
+	case "GARMIN_RATE_LIMIT":
+		if c.GarminRateLimit, err = parseInt(key, value); err != nil {
+			return err
+		}
+	case "UPLOAD_RATE_LIMIT", "IDO_RATE_LIMIT":
+		if c.UploadRateLimit, err = parseInt(key, value); err != nil {
+			return err
+		}
+	case "RETRY_MAX_ATTEMPTS":
+		if c.RetryMaxAttempts, err = parseInt(key, value); err != nil {
+			return err
+		}
+	case "RETRY_INITIAL_DELAY":
+		if c.RetryInitialDelay, err = parseDuration(key, value); err != nil {
+			return err
+		}
+	case "RETRY_MAX_DELAY":
+		if c.RetryMaxDelay, err = parseDuration(key, value); err != nil {
+			return err
+		}
+	case "QUIET_HOURS":
+		c.QuietHours = value
+	case "METRICS_ADDR":
+		c.MetricsAddr = value
+	case "API_ADDR":
+		c.APIAddr = value
+	case "API_TOKEN":
+		c.APIToken = value
+	case "NOTIFY_ON":
+		c.Notify.On = value
+	case "NOTIFY_WEBHOOK_URL":
+		c.Notify.WebhookURL = value
+	case "NOTIFY_SMTP_HOST":
+		c.Notify.SMTPHost = value
+	case "NOTIFY_SMTP_PORT":
+		if c.Notify.SMTPPort, err = parseInt(key, value); err != nil {
+			return err
+		}
+	case "NOTIFY_SMTP_USERNAME":
+		c.Notify.SMTPUsername = value
+	case "NOTIFY_SMTP_PASSWORD":
+		c.Notify.SMTPPassword = value
+	case "NOTIFY_SMTP_FROM":
+		c.Notify.SMTPFrom = value
+	case "NOTIFY_SMTP_TO":
+		c.Notify.SMTPTo = splitList(value)
+	case "NOTIFY_COMMAND":
+		c.Notify.Command = value
+	case "NAME_TEMPLATE":
+		c.NameTemplate = value
+	default:
+		// NAME_TEMPLATE_<SPORT>, e.g. NAME_TEMPLATE_BIKE
+		if name, ok := strings.CutPrefix(key, "NAME_TEMPLATE_"); ok {
+			sport, err := garmin.ParseSport(name)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			if c.SportNameTemplates == nil {
+				c.SportNameTemplates = make(map[garmin.Sport]string)
+			}
+			c.SportNameTemplates[sport] = value
+		}
+	}
+	return nil
+}
+
+// resolvePaths makes the data directory absolute, relative to the config
+// file's directory, and the other paths relative to the data directory, so
+// the tool behaves the same whatever the working directory (e.g. under cron).
+// A profile's data directory is DATA_DIR/profiles/<name>.
+func (c *Config) resolvePaths(configDir string) error {
+	if c.DataDir == "" {
+		c.DataDir = configDir
+	} else if !filepath.IsAbs(c.DataDir) {
+		c.DataDir = filepath.Join(configDir, c.DataDir)
+	}
+
+	dataDir, err := filepath.Abs(c.DataDir)
+	if err != nil {
+		return fmt.Errorf("invalid DATA_DIR: %w", err)
+	}
+	c.DataDir = dataDir
+
+	// Each profile keeps its data in its own directory
+	if c.Profile != "" {
+		c.DataDir = filepath.Join(c.DataDir, "profiles", c.Profile)
+	}
+
+	for _, path := range []*string{&c.LedgerPath, &c.GarminTokenDir, &c.ArchiveDir, &c.FolderDestinationDir} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(c.DataDir, *path)
+		}
+	}
+	return nil
+}
+
+// HasDestination reports whether a destination is enabled
+func (c *Config) HasDestination(name string) bool {
+	for _, dest := range c.Destinations {
+		if dest == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateGarmin checks the settings needed by commands that only talk to
+// Garmin, such as export
+func (c *Config) ValidateGarmin() error {
 	if c.GarminUsername == "" {
 		return fmt.Errorf("GARMIN_USERNAME is required")
 	}
 	if c.GarminPassword == "" {
 		return fmt.Errorf("GARMIN_PASSWORD is required")
 	}
-	if c.IdoUsername == "" {
+	switch c.GarminClient {
+	case "python", "native":
+	default:
+		return fmt.Errorf("unknown GARMIN_CLIENT %q (use python or native)", c.GarminClient)
+	}
+	if (c.GarminConsumerKey == "") != (c.GarminConsumerSecret == "") {
+		return fmt.Errorf("GARMIN_CONSUMER_KEY and GARMIN_CONSUMER_SECRET must be set together")
+	}
+	return nil
+}
+
+// Validate checks if all required configuration values are present
+func (c *Config) Validate() error {
+	if err := c.ValidateGarmin(); err != nil {
+		return err
+	}
+	if len(c.Destinations) == 0 {
+		return fmt.Errorf("DESTINATIONS must list at least one destination")
+	}
+	for _, dest := range c.Destinations {
+		switch dest {
+		case "ido", "folder":
+		default:
+			return fmt.Errorf("unknown destination %q in DESTINATIONS (use ido or folder)", dest)
+		}
+	}
+	if c.HasDestination("ido") && c.IdoUsername == "" {
 		return fmt.Errorf("IDO_USERNAME is required")
 	}
-	if c.IdoPassword == "" {
+	if c.HasDestination("ido") && c.IdoPassword == "" {
 		return fmt.Errorf("IDO_PASSWORD is required")
 	}
+	if c.HasDestination("folder") && c.FolderDestinationDir == "" {
+		return fmt.Errorf("FOLDER_DESTINATION_DIR is required for the folder destination")
+	}
+	if len(c.Sports) == 0 {
+		return fmt.Errorf("SPORTS must list at least one sport")
+	}
+	if _, err := filter.New(c.Filter); err != nil {
+		return fmt.Errorf("invalid activity filter: %w", err)
+	}
+	if c.DownloadConcurrency < 1 {
+		return fmt.Errorf("DOWNLOAD_CONCURRENCY must be at least 1")
+	}
+	if c.UploadConcurrency < 1 {
+		return fmt.Errorf("UPLOAD_CONCURRENCY must be at least 1")
+	}
+	if c.GarminRateLimit < 0 || c.UploadRateLimit < 0 {
+		return fmt.Errorf("GARMIN_RATE_LIMIT and UPLOAD_RATE_LIMIT must not be negative")
+	}
+	if c.RetryMaxAttempts < 1 {
+		return fmt.Errorf("RETRY_MAX_ATTEMPTS must be at least 1")
+	}
+	if c.ArchiveMaxAge < 0 || c.ArchiveMaxCount < 0 || c.ArchiveMaxSize < 0 {
+		return fmt.Errorf("ARCHIVE_MAX_AGE, ARCHIVE_MAX_COUNT and ARCHIVE_MAX_SIZE must not be negative")
+	}
+	if c.PollInterval <= 0 {
+		return fmt.Errorf("POLL_INTERVAL must be positive")
+	}
+	if c.PollLookbackDays < 0 {
+		return fmt.Errorf("POLL_LOOKBACK_DAYS must not be negative")
+	}
+	if _, err := daemon.ParseQuietHours(c.QuietHours); err != nil {
+		return fmt.Errorf("QUIET_HOURS: %w", err)
+	}
+	if c.APIAddr != "" && c.APIToken == "" {
+		return fmt.Errorf("API_TOKEN is required to enable the control API")
+	}
+	if _, err := notify.New(c.Notify); err != nil {
+		return fmt.Errorf("invalid notification settings: %w", err)
+	}
 	return nil
 }
+
+// parseInt parses an integer configuration value
+func parseInt(key, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// parseDuration parses a duration configuration value such as "30s" or "5m"
+func parseDuration(key, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// parseAge parses a retention age such as "30d", "8w" or any Go duration
+func parseAge(key, value string) (time.Duration, error) {
+	if n := len(value); n > 1 && (value[n-1] == 'd' || value[n-1] == 'w') {
+		count, err := strconv.Atoi(value[:n-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		if value[n-1] == 'w' {
+			count *= 7
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+	return parseDuration(key, value)
+}
+
+// parseSize parses a size in bytes, with an optional KB, MB or GB suffix
+func parseSize(key, value string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n * factor, nil
+}
+
+// splitList parses a comma-separated list, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,262 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	gosync "sync"
+	"time"
+
+	"garmin-to-ido/internal/destination"
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/metrics"
+	"garmin-to-ido/internal/notify"
+	"garmin-to-ido/internal/sync"
+)
+
+// Options controls the daemon schedule
+type Options struct {
+	// Interval between two Garmin polls
+	Interval time.Duration
+	// LookbackDays is the number of days before today included in each poll
+	LookbackDays int
+	// QuietHours is an optional daily window during which polls are skipped
+	QuietHours *QuietHours
+	// Location is the athlete's time zone, in which quiet hours and today are
+	// evaluated. Defaults to the local time zone.
+	Location *time.Location
+	// Notifier receives the report of every poll. Nil disables notifications.
+	Notifier *notify.Dispatcher
+	// Metrics records the time of successful polls. Nil disables it.
+	Metrics *metrics.Recorder
+	Debug   bool
+	// Name prefixes the log messages, to tell apart several daemons (profiles)
+	// running in the same process
+	Name string
+	// GarminLoggedOut is set when the Garmin client could not log in yet: it
+	// logs in before each poll until that succeeds, and the failures are
+	// reported like those of any poll
+	GarminLoggedOut bool
+}
+
+// Daemon keeps the Garmin and destination sessions alive and periodically
+// runs the syncer
+type Daemon struct {
+	garminClient garmin.GarminClient
+	destinations []destination.Destination
+	syncer       *sync.Syncer
+	opts         Options
+
+	// garminStale is set when the last Garmin call failed, so the client is
+	// logged in again before the next poll
+	garminStale bool
+
+	// runMu serializes the sync runs: polls and the ones requested through
+	// the control API
+	runMu gosync.Mutex
+	// closed is set once the sessions are released, under runMu
+	closed bool
+
+	lastMu     gosync.Mutex
+	lastReport *sync.Report
+}
+
+// ErrClosed is returned by the runs requested after Close
+var ErrClosed = errors.New("the daemon is stopping")
+
+// New creates a daemon around already logged-in clients, except for the
+// Garmin client with opts.GarminLoggedOut
+func New(garminClient garmin.GarminClient, destinations []destination.Destination, syncer *sync.Syncer, opts Options) *Daemon {
+	return &Daemon{
+		garminClient: garminClient,
+		destinations: destinations,
+		syncer:       syncer,
+		opts:         opts,
+		garminStale:  opts.GarminLoggedOut,
+	}
+}
+
+// Run polls Garmin until the context is cancelled. A first poll happens immediately.
+func (d *Daemon) Run(ctx context.Context) error {
+	d.logf("Polling Garmin every %s (lookback: %d day(s))", d.opts.Interval, d.opts.LookbackDays)
+	if d.opts.QuietHours != nil {
+		d.logf("Quiet hours: %s", d.opts.QuietHours)
+	}
+
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+
+	d.poll(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			d.logf("Stopping daemon")
+			return nil
+		case now := <-ticker.C:
+			d.poll(now)
+		}
+	}
+}
+
+// poll runs one sync cycle, restoring sessions first if needed
+func (d *Daemon) poll(now time.Time) {
+	if d.opts.Location != nil {
+		now = now.In(d.opts.Location)
+	}
+	if d.opts.QuietHours.Contains(now) {
+		d.logf("Quiet hours, skipping poll")
+		return
+	}
+
+	today := dateOf(now)
+	d.Sync(today.AddDate(0, 0, -d.opts.LookbackDays), today)
+}
+
+// Sync syncs the activities between two dates (inclusive), restoring the
+// sessions first if needed. It waits for a running sync to finish.
+func (d *Daemon) Sync(start, end time.Time) (*sync.Report, error) {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+
+	if err := d.ensureSessions(); err != nil {
+		return d.sessionError(err)
+	}
+
+	d.logf("Syncing activities from %s to %s", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	report, err := d.syncer.SyncActivities(start, end, d.opts.Debug)
+	d.finish(report)
+	if err != nil {
+		d.logf("✗ Sync failed: %v", err)
+		d.garminStale = true
+		return report, err
+	}
+	if report.HasAuthFailure() {
+		d.garminStale = true
+	}
+	if !report.HasFailures() {
+		d.opts.Metrics.SyncSucceeded(time.Now())
+	}
+	d.logf("✓ Sync completed: %d synced, %d skipped, %d failed", report.Synced, report.Skipped, report.Failed)
+	return report, nil
+}
+
+// RetryFailed replays the failed uploads of the given activities (all if
+// empty) from the archive. It waits for a running sync to finish.
+func (d *Daemon) RetryFailed(ids map[int64]bool) (*sync.Report, error) {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+
+	if err := d.ensureSessions(); err != nil {
+		return d.sessionError(err)
+	}
+
+	d.logf("Retrying failed uploads")
+	report, err := d.syncer.RetryFailed(ids, d.opts.Debug)
+	d.finish(report)
+	if err != nil {
+		d.logf("✗ Retry failed: %v", err)
+		return report, err
+	}
+	d.logf("✓ Retry completed: %d synced, %d skipped, %d failed", report.Synced, report.Skipped, report.Failed)
+	return report, nil
+}
+
+// Close waits for a running sync to finish, so no upload is cut short, then
+// releases the sessions with release. Later runs fail with ErrClosed.
+func (d *Daemon) Close(release func()) {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+	if !d.closed {
+		d.closed = true
+		release()
+	}
+}
+
+// LastReport returns the report of the last sync or retry, nil before the
+// first one
+func (d *Daemon) LastReport() *sync.Report {
+	d.lastMu.Lock()
+	defer d.lastMu.Unlock()
+	return d.lastReport
+}
+
+// Today returns the current date in the athlete's time zone
+func (d *Daemon) Today() time.Time {
+	now := time.Now()
+	if d.opts.Location != nil {
+		now = now.In(d.opts.Location)
+	}
+	return dateOf(now)
+}
+
+// dateOf returns the date of now, as the syncer expects it
+func dateOf(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// finish keeps the report of a run and notifies it
+func (d *Daemon) finish(report *sync.Report) {
+	report.Profile = d.opts.Name
+	d.opts.Notifier.Send(report)
+
+	d.lastMu.Lock()
+	d.lastReport = report
+	d.lastMu.Unlock()
+}
+
+// sessionError handles a failure to restore the sessions, e.g. a rejected
+// login or a Garmin session that needs garmin-login. It is reported like a
+// failed run of the auth or reauth_required class. Later polls usually fail
+// the same way, so it is only notified if the last run did not already fail
+// with the same class.
+func (d *Daemon) sessionError(err error) (*sync.Report, error) {
+	d.logf("✗ %v", err)
+
+	report := sync.FailedReport(sync.ErrorAuth, err)
+	report.Profile = d.opts.Name
+	if last := d.LastReport(); last == nil || last.ErrorClass != report.ErrorClass {
+		d.opts.Notifier.Send(report)
+	}
+
+	d.lastMu.Lock()
+	d.lastReport = report
+	d.lastMu.Unlock()
+	return report, err
+}
+
+// ensureSessions restores the Garmin and destination sessions if they were lost
+func (d *Daemon) ensureSessions() error {
+	if d.garminStale {
+		d.logf("Logging in to Garmin Connect again")
+		d.garminClient.Logout()
+		if err := d.garminClient.Login(); err != nil {
+			return fmt.Errorf("failed to restore Garmin session: %w", err)
+		}
+		d.garminStale = false
+	}
+
+	for _, dest := range d.destinations {
+		if keeper, ok := dest.(destination.SessionKeeper); ok {
+			if err := keeper.EnsureSession(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// logf logs a message, prefixed with the daemon's name if any
+func (d *Daemon) logf(format string, args ...any) {
+	if d.opts.Name != "" {
+		format = "[" + d.opts.Name + "] " + format
+	}
+	log.Printf(format, args...)
+}
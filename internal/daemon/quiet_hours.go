@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily time window during which no sync is triggered.
+// The window may wrap around midnight (e.g. 22:00-06:00).
+type QuietHours struct {
+	start int // minutes after midnight
+	end   int // minutes after midnight
+}
+
+// ParseQuietHours parses a window in the form "HH:MM-HH:MM". An empty string
+// disables quiet hours and returns nil.
+func ParseQuietHours(value string) (*QuietHours, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid quiet hours %q, expected HH:MM-HH:MM", value)
+	}
+
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours %q: %w", value, err)
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours %q: %w", value, err)
+	}
+
+	return &QuietHours{start: start, end: end}, nil
+}
+
+// Contains reports whether t falls inside the quiet window
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil || q.start == q.end {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if q.start < q.end {
+		return minute >= q.start && minute < q.end
+	}
+	// Window wraps around midnight
+	return minute >= q.start || minute < q.end
+}
+
+// String formats the window as "HH:MM-HH:MM"
+func (q *QuietHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", q.start/60, q.start%60, q.end/60, q.end%60)
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
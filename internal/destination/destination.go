@@ -0,0 +1,82 @@
+package destination
+
+import (
+	"io"
+	"time"
+)
+
+// Upload describes an activity to send to a destination
+type Upload struct {
+	ActivityID   int64
+	ActivityName string
+	ActivityType string    // Garmin activity type key
+	StartTime    time.Time // activity start
+	Duration     float64   // seconds
+	Distance     float64   // meters
+	FitData      []byte
+
+	// Output receives the upload progress
+	Output io.Writer
+	Debug  bool
+}
+
+// Destination is a platform activities are uploaded to. It is the
+// counterpart of garmin.GarminClient on the source side.
+type Destination interface {
+	// Name identifies the destination in the ledger and in reports
+	Name() string
+	Login() error
+	// Upload sends the activity and returns the key it was stored under
+	Upload(upload Upload) (string, error)
+	// Exists reports whether the destination already has the activity
+	Exists(upload Upload) (bool, error)
+	Close() error
+}
+
+// SessionKeeper is implemented by destinations whose session can expire,
+// so long-running processes can restore it before each run
+type SessionKeeper interface {
+	EnsureSession() error
+}
+
+// RemoteActivity is an activity already stored on a destination
+type RemoteActivity struct {
+	ID        string
+	Name      string
+	StartTime time.Time
+	Duration  float64 // seconds, 0 if unknown
+}
+
+// Lister is implemented by destinations that can list the activities they
+// already have, so activities uploaded by other means are not duplicated
+type Lister interface {
+	// ListActivities returns the activities started between two dates (inclusive)
+	ListActivities(from, to time.Time) ([]RemoteActivity, error)
+}
+
+// Tolerances used to match an activity against the ones a destination has.
+// Destinations may round the start time and recompute the duration.
+const (
+	matchStartTolerance    = 2 * time.Minute
+	matchDurationTolerance = 0.05 // relative
+	matchDurationSlack     = 60.0 // seconds
+)
+
+// FindMatch looks for a remote activity with the same start time and duration
+// as the upload
+func FindMatch(remote []RemoteActivity, upload Upload) (RemoteActivity, bool) {
+	for _, activity := range remote {
+		delta := activity.StartTime.Sub(upload.StartTime)
+		if delta < -matchStartTolerance || delta > matchStartTolerance {
+			continue
+		}
+		if activity.Duration > 0 && upload.Duration > 0 {
+			tolerance := max(upload.Duration*matchDurationTolerance, matchDurationSlack)
+			if diff := activity.Duration - upload.Duration; diff < -tolerance || diff > tolerance {
+				continue
+			}
+		}
+		return activity, true
+	}
+	return RemoteActivity{}, false
+}
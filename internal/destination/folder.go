@@ -0,0 +1,61 @@
+package destination
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Folder is a destination that copies FIT files into a local directory,
+// e.g. one synchronized by a cloud storage client
+type Folder struct {
+	dir string
+}
+
+// NewFolder creates a folder destination writing into dir
+func NewFolder(dir string) *Folder {
+	return &Folder{dir: dir}
+}
+
+// Name returns "folder"
+func (f *Folder) Name() string {
+	return "folder"
+}
+
+// Login creates the target directory
+func (f *Folder) Login() error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create folder destination: %w", err)
+	}
+	return nil
+}
+
+// Upload writes the FIT file and returns its path
+func (f *Folder) Upload(upload Upload) (string, error) {
+	path := f.path(upload)
+	if err := os.WriteFile(path, upload.FitData, 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	fmt.Fprintf(upload.Output, "    → Copied FIT file to %s\n", path)
+	return path, nil
+}
+
+// Exists reports whether the FIT file is already in the folder
+func (f *Folder) Exists(upload Upload) (bool, error) {
+	_, err := os.Stat(f.path(upload))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Close is a no-op
+func (f *Folder) Close() error {
+	return nil
+}
+
+// path returns the file name used for an activity
+func (f *Folder) path(upload Upload) string {
+	name := fmt.Sprintf("%s_%d.fit", upload.StartTime.Format("20060102_150405"), upload.ActivityID)
+	return filepath.Join(f.dir, name)
+}
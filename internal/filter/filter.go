@@ -0,0 +1,145 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"garmin-to-ido/internal/garmin"
+)
+
+// Environment restricts activities to indoor or outdoor ones
+type Environment string
+
+const (
+	EnvironmentAny     Environment = ""
+	EnvironmentIndoor  Environment = "indoor"
+	EnvironmentOutdoor Environment = "outdoor"
+)
+
+// Filter decides which Garmin activities are synced. Type patterns are
+// matched against the Garmin type key and may use shell wildcards, e.g.
+// "*_cycling".
+type Filter struct {
+	IncludeTypes []string
+	ExcludeTypes []string
+	MinDuration  time.Duration
+	MinDistance  float64 // meters
+	Name         *regexp.Regexp
+	ExcludeName  *regexp.Regexp
+	Environment  Environment
+}
+
+// Options holds the raw filter settings, as found in the configuration
+type Options struct {
+	IncludeTypes     []string
+	ExcludeTypes     []string
+	MinDuration      time.Duration
+	MinDistanceKm    float64
+	NameRegex        string
+	ExcludeNameRegex string
+	Environment      string
+}
+
+// New validates the options and builds a filter
+func New(opts Options) (*Filter, error) {
+	f := &Filter{
+		IncludeTypes: lowerAll(opts.IncludeTypes),
+		ExcludeTypes: lowerAll(opts.ExcludeTypes),
+		MinDuration:  opts.MinDuration,
+		MinDistance:  opts.MinDistanceKm * 1000,
+	}
+
+	for _, pattern := range append(f.IncludeTypes, f.ExcludeTypes...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid activity type pattern %q: %w", pattern, err)
+		}
+	}
+
+	if opts.NameRegex != "" {
+		re, err := regexp.Compile(opts.NameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name regex: %w", err)
+		}
+		f.Name = re
+	}
+	if opts.ExcludeNameRegex != "" {
+		re, err := regexp.Compile(opts.ExcludeNameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude name regex: %w", err)
+		}
+		f.ExcludeName = re
+	}
+
+	switch env := Environment(strings.ToLower(opts.Environment)); env {
+	case EnvironmentAny, EnvironmentIndoor, EnvironmentOutdoor:
+		f.Environment = env
+	case "any":
+		f.Environment = EnvironmentAny
+	default:
+		return nil, fmt.Errorf("invalid environment %q (use indoor, outdoor or any)", opts.Environment)
+	}
+
+	return f, nil
+}
+
+// Match reports whether the activity passes the filter. When it does not,
+// the reason explains which rule rejected it.
+func (f *Filter) Match(activity garmin.Activity) (bool, string) {
+	if f == nil {
+		return true, ""
+	}
+
+	typeKey := strings.ToLower(activity.ActivityType)
+	if len(f.IncludeTypes) > 0 && !matchAny(f.IncludeTypes, typeKey) {
+		return false, fmt.Sprintf("type %s not included", activity.ActivityType)
+	}
+	if matchAny(f.ExcludeTypes, typeKey) {
+		return false, fmt.Sprintf("type %s excluded", activity.ActivityType)
+	}
+
+	duration := time.Duration(activity.Duration * float64(time.Second))
+	if f.MinDuration > 0 && duration < f.MinDuration {
+		return false, fmt.Sprintf("duration %s shorter than %s", duration.Round(time.Second), f.MinDuration)
+	}
+	if f.MinDistance > 0 && activity.Distance < f.MinDistance {
+		return false, fmt.Sprintf("distance %.2f km shorter than %.2f km", activity.Distance/1000, f.MinDistance/1000)
+	}
+
+	if f.Name != nil && !f.Name.MatchString(activity.ActivityName) {
+		return false, fmt.Sprintf("name does not match %s", f.Name)
+	}
+	if f.ExcludeName != nil && f.ExcludeName.MatchString(activity.ActivityName) {
+		return false, fmt.Sprintf("name matches %s", f.ExcludeName)
+	}
+
+	switch {
+	case f.Environment == EnvironmentIndoor && !activity.IsIndoor():
+		return false, "outdoor activity"
+	case f.Environment == EnvironmentOutdoor && activity.IsIndoor():
+		return false, "indoor activity"
+	}
+
+	return true, ""
+}
+
+// matchAny reports whether typeKey matches one of the patterns
+func matchAny(patterns []string, typeKey string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, typeKey); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerAll lowercases every item of a list
+func lowerAll(items []string) []string {
+	lowered := make([]string, len(items))
+	for i, item := range items {
+		lowered[i] = strings.ToLower(item)
+	}
+	return lowered
+}
@@ -0,0 +1,94 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"garmin-to-ido/internal/garmin"
+)
+
+func TestMatch(t *testing.T) {
+	ride := garmin.Activity{
+		ActivityName: "Morning Ride",
+		ActivityType: "road_biking",
+		Distance:     42500,
+		Duration:     5400,
+	}
+	indoor := garmin.Activity{
+		ActivityName: "Zwift - Watopia",
+		ActivityType: "virtual_ride",
+		Distance:     20000,
+		Duration:     3600,
+	}
+
+	for _, test := range []struct {
+		name       string
+		opts       Options
+		activity   garmin.Activity
+		wantMatch  bool
+		wantReason string
+	}{
+		{name: "no rules", activity: ride, wantMatch: true},
+		{name: "included type", opts: Options{IncludeTypes: []string{"road_biking"}}, activity: ride, wantMatch: true},
+		{name: "included wildcard", opts: Options{IncludeTypes: []string{"running", "*_biking"}}, activity: ride, wantMatch: true},
+		{name: "included in another case", opts: Options{IncludeTypes: []string{"Road_Biking"}}, activity: ride, wantMatch: true},
+		{name: "not included", opts: Options{IncludeTypes: []string{"*_running"}}, activity: ride,
+			wantReason: "type road_biking not included"},
+		{name: "excluded type", opts: Options{ExcludeTypes: []string{"road_*"}}, activity: ride,
+			wantReason: "type road_biking excluded"},
+		{name: "exclusion wins", opts: Options{IncludeTypes: []string{"*"}, ExcludeTypes: []string{"virtual_ride"}}, activity: indoor,
+			wantReason: "type virtual_ride excluded"},
+		{name: "long enough", opts: Options{MinDuration: 90 * time.Minute}, activity: ride, wantMatch: true},
+		{name: "too short", opts: Options{MinDuration: 2 * time.Hour}, activity: ride,
+			wantReason: "duration 1h30m0s shorter than 2h0m0s"},
+		{name: "far enough", opts: Options{MinDistanceKm: 42.5}, activity: ride, wantMatch: true},
+		{name: "too close", opts: Options{MinDistanceKm: 50}, activity: ride,
+			wantReason: "distance 42.50 km shorter than 50.00 km"},
+		{name: "name matches", opts: Options{NameRegex: "(?i)ride$"}, activity: ride, wantMatch: true},
+		{name: "name does not match", opts: Options{NameRegex: "^Race"}, activity: ride,
+			wantReason: "name does not match ^Race"},
+		{name: "name excluded", opts: Options{ExcludeNameRegex: "Zwift"}, activity: indoor,
+			wantReason: "name matches Zwift"},
+		{name: "indoor only", opts: Options{Environment: "indoor"}, activity: ride,
+			wantReason: "outdoor activity"},
+		{name: "outdoor only", opts: Options{Environment: "Outdoor"}, activity: indoor,
+			wantReason: "indoor activity"},
+		{name: "any environment", opts: Options{Environment: "any"}, activity: indoor, wantMatch: true},
+		{name: "type checked first", opts: Options{ExcludeTypes: []string{"virtual_ride"}, MinDistanceKm: 100}, activity: indoor,
+			wantReason: "type virtual_ride excluded"},
+	} {
+		f, err := New(test.opts)
+		if err != nil {
+			t.Errorf("%s: New: %v", test.name, err)
+			continue
+		}
+		match, reason := f.Match(test.activity)
+		if match != test.wantMatch || reason != test.wantReason {
+			t.Errorf("%s: Match = %v, %q, want %v, %q", test.name, match, reason, test.wantMatch, test.wantReason)
+		}
+	}
+
+	// A nil filter lets everything through
+	var none *Filter
+	if match, reason := none.Match(ride); !match || reason != "" {
+		t.Errorf("nil filter: Match = %v, %q", match, reason)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, test := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{IncludeTypes: []string{"[road"}}, "invalid activity type pattern"},
+		{Options{ExcludeTypes: []string{"road\\"}}, "invalid activity type pattern"},
+		{Options{NameRegex: "(ride"}, "invalid name regex"},
+		{Options{ExcludeNameRegex: "*"}, "invalid exclude name regex"},
+		{Options{Environment: "garage"}, "invalid environment"},
+	} {
+		if _, err := New(test.opts); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("New(%+v) error = %v, want %q", test.opts, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package fit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Global message numbers of the messages read from the file
+const (
+	mesgFileID   = 0
+	mesgSport    = 12
+	mesgSession  = 18
+	mesgActivity = 34
+)
+
+// fitEpoch is the origin of FIT timestamps: 1989-12-31 00:00:00 UTC
+var fitEpoch = time.Date(1989, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// ErrNotFIT is returned for data without a FIT file header
+var ErrNotFIT = errors.New("not a FIT file")
+
+// Info is the summary of an activity read from a FIT file
+type Info struct {
+	StartTime time.Time // UTC
+	// LocalOffset is the offset of the device's local time from UTC, if the
+	// file records it
+	LocalOffset    time.Duration
+	HasLocalOffset bool
+
+	Sport    uint8
+	SubSport uint8
+	Duration float64 // seconds
+	Distance float64 // meters
+}
+
+// LocalStartTime returns the start time in the device's time zone, or in
+// loc if the file does not record it
+func (i *Info) LocalStartTime(loc *time.Location) time.Time {
+	if i.HasLocalOffset {
+		return i.StartTime.In(time.FixedZone("", int(i.LocalOffset/time.Second)))
+	}
+	return i.StartTime.In(loc)
+}
+
+// TypeKey maps the FIT sport and sub-sport to a Garmin Connect activity type
+// key, e.g. "road_biking" or "lap_swimming"
+func (i *Info) TypeKey() string {
+	if key, ok := subSportKeys[[2]uint8{i.Sport, i.SubSport}]; ok {
+		return key
+	}
+	if key, ok := sportKeys[i.Sport]; ok {
+		return key
+	}
+	return "other"
+}
+
+// definition describes the layout of the data messages of a local message type
+type definition struct {
+	global    uint16
+	bigEndian bool
+	fields    []fieldDef
+	devSize   int // total size of the developer fields, skipped
+}
+
+type fieldDef struct {
+	num  uint8
+	size int
+}
+
+// Parse reads the activity summary from a FIT file. Only the messages needed
+// to describe the activity are decoded; everything else is skipped.
+func Parse(data []byte) (*Info, error) {
+	if len(data) < 12 {
+		return nil, ErrNotFIT
+	}
+	headerSize := int(data[0])
+	if headerSize < 12 || len(data) < headerSize || string(data[8:12]) != ".FIT" {
+		return nil, ErrNotFIT
+	}
+	dataSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	end := headerSize + dataSize
+	if end > len(data) {
+		return nil, fmt.Errorf("truncated FIT file: %d of %d bytes", len(data), end)
+	}
+
+	info := &Info{}
+	var fileCreated, activityTime, activityLocal uint32
+	definitions := make(map[uint8]*definition)
+
+	pos := headerSize
+	for pos < end {
+		header := data[pos]
+		pos++
+
+		// Compressed timestamp header: data message of local type 0-3
+		if header&0x80 != 0 {
+			def, ok := definitions[(header>>5)&0x03]
+			if !ok {
+				return nil, fmt.Errorf("data message without definition at offset %d", pos-1)
+			}
+			pos += def.size()
+			continue
+		}
+
+		local := header & 0x0F
+		if header&0x40 != 0 {
+			// Definition message
+			if pos+5 > end {
+				return nil, fmt.Errorf("truncated definition at offset %d", pos)
+			}
+			def := &definition{bigEndian: data[pos+1] == 1}
+			def.global = def.order().Uint16(data[pos+2 : pos+4])
+			count := int(data[pos+4])
+			pos += 5
+			if pos+count*3 > end {
+				return nil, fmt.Errorf("truncated definition at offset %d", pos)
+			}
+			for i := 0; i < count; i++ {
+				def.fields = append(def.fields, fieldDef{num: data[pos], size: int(data[pos+1])})
+				pos += 3
+			}
+			if header&0x20 != 0 {
+				if pos >= end {
+					return nil, fmt.Errorf("truncated definition at offset %d", pos)
+				}
+				devCount := int(data[pos])
+				pos++
+				if pos+devCount*3 > end {
+					return nil, fmt.Errorf("truncated definition at offset %d", pos)
+				}
+				for i := 0; i < devCount; i++ {
+					def.devSize += int(data[pos+1])
+					pos += 3
+				}
+			}
+			definitions[local] = def
+			continue
+		}
+
+		// Data message
+		def, ok := definitions[local]
+		if !ok {
+			return nil, fmt.Errorf("data message without definition at offset %d", pos-1)
+		}
+		if pos+def.size() > end {
+			return nil, fmt.Errorf("truncated data message at offset %d", pos)
+		}
+
+		fieldPos := pos
+		for _, field := range def.fields {
+			value := data[fieldPos : fieldPos+field.size]
+			fieldPos += field.size
+
+			switch def.global {
+			case mesgFileID:
+				if field.num == 4 {
+					fileCreated = def.uint32(value)
+				}
+			case mesgSport:
+				switch field.num {
+				case 0:
+					info.Sport = uint8Value(value, info.Sport)
+				case 1:
+					info.SubSport = uint8Value(value, info.SubSport)
+				}
+			case mesgSession:
+				// The first session describes the activity
+				switch field.num {
+				case 2:
+					if info.StartTime.IsZero() {
+						if t := def.uint32(value); valid32(t) {
+							info.StartTime = timestamp(t)
+						}
+					}
+				case 5:
+					info.Sport = uint8Value(value, info.Sport)
+				case 6:
+					info.SubSport = uint8Value(value, info.SubSport)
+				case 7:
+					if v := def.uint32(value); valid32(v) {
+						info.Duration = float64(v) / 1000
+					}
+				case 9:
+					if v := def.uint32(value); valid32(v) {
+						info.Distance = float64(v) / 100
+					}
+				}
+			case mesgActivity:
+				switch field.num {
+				case 253:
+					activityTime = def.uint32(value)
+				case 5:
+					activityLocal = def.uint32(value)
+				}
+			}
+		}
+		pos += def.size()
+	}
+
+	if info.StartTime.IsZero() && valid32(fileCreated) {
+		info.StartTime = timestamp(fileCreated)
+	}
+	if info.StartTime.IsZero() {
+		return nil, fmt.Errorf("FIT file has no start time")
+	}
+	if valid32(activityTime) && valid32(activityLocal) {
+		info.LocalOffset = time.Duration(int64(activityLocal)-int64(activityTime)) * time.Second
+		info.HasLocalOffset = true
+	}
+
+	return info, nil
+}
+
+// size returns the size of a data message, header excluded
+func (d *definition) size() int {
+	size := d.devSize
+	for _, field := range d.fields {
+		size += field.size
+	}
+	return size
+}
+
+func (d *definition) order() binary.ByteOrder {
+	if d.bigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// uint32 decodes a 4-byte field, returning the invalid value otherwise
+func (d *definition) uint32(value []byte) uint32 {
+	if len(value) != 4 {
+		return 0xFFFFFFFF
+	}
+	return d.order().Uint32(value)
+}
+
+// uint8Value decodes a 1-byte enum field, keeping current if it is invalid
+func uint8Value(value []byte, current uint8) uint8 {
+	if len(value) != 1 || value[0] == 0xFF {
+		return current
+	}
+	return value[0]
+}
+
+// valid32 reports whether a uint32 field is set. Zero is treated as unset
+// too, as it is never a meaningful timestamp.
+func valid32(v uint32) bool {
+	return v != 0 && v != 0xFFFFFFFF
+}
+
+// timestamp converts a FIT timestamp to a time
+func timestamp(v uint32) time.Time {
+	return fitEpoch.Add(time.Duration(v) * time.Second)
+}
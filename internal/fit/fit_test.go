@@ -0,0 +1,285 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// fitBuilder writes the records of a FIT file by hand
+type fitBuilder struct {
+	records bytes.Buffer
+}
+
+// field is a field definition: field number and size in bytes
+type field struct {
+	num, size uint8
+}
+
+// define writes a definition message. devSizes are the sizes of developer
+// fields, which add the developer data flag.
+func (b *fitBuilder) define(local uint8, global uint16, bigEndian bool, fields []field, devSizes ...uint8) {
+	header := 0x40 | local
+	if len(devSizes) > 0 {
+		header |= 0x20
+	}
+	order := binary.AppendByteOrder(binary.LittleEndian)
+	arch := byte(0)
+	if bigEndian {
+		order, arch = binary.BigEndian, 1
+	}
+	b.records.Write([]byte{header, 0, arch})
+	b.records.Write(order.AppendUint16(nil, global))
+	b.records.WriteByte(byte(len(fields)))
+	for _, f := range fields {
+		b.records.Write([]byte{f.num, f.size, 0})
+	}
+	if len(devSizes) > 0 {
+		b.records.WriteByte(byte(len(devSizes)))
+		for i, size := range devSizes {
+			b.records.Write([]byte{byte(i), size, 0})
+		}
+	}
+}
+
+// data writes a data message with a normal header
+func (b *fitBuilder) data(local uint8, values ...[]byte) {
+	b.records.WriteByte(local)
+	for _, value := range values {
+		b.records.Write(value)
+	}
+}
+
+// compressed writes a data message with a compressed timestamp header
+func (b *fitBuilder) compressed(local, offset uint8, values ...[]byte) {
+	b.records.WriteByte(0x80 | local<<5 | offset&0x1F)
+	for _, value := range values {
+		b.records.Write(value)
+	}
+}
+
+// file returns the FIT file: 14-byte header, records and CRC, which Parse
+// does not check
+func (b *fitBuilder) file() []byte {
+	header := []byte{14, 0x20, 0x08, 0x08}
+	header = binary.LittleEndian.AppendUint32(header, uint32(b.records.Len()))
+	header = append(header, ".FIT"...)
+	header = append(header, 0, 0)
+	file := append(header, b.records.Bytes()...)
+	return append(file, 0, 0)
+}
+
+func le32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// fitTime returns the FIT timestamp of t
+func fitTime(t time.Time) uint32 {
+	return uint32(t.Sub(fitEpoch) / time.Second)
+}
+
+var (
+	rideStart = time.Date(2025, 1, 15, 6, 15, 0, 0, time.UTC)
+
+	fileIDFields  = []field{{4, 4}}
+	sessionFields = []field{{2, 4}, {5, 1}, {6, 1}, {7, 4}, {9, 4}}
+	// activityFields are the timestamp and local_timestamp
+	activityFields = []field{{253, 4}, {5, 4}}
+	// recordFields are a heart rate and a distance, in a record message
+	recordFields = []field{{3, 1}, {5, 4}}
+)
+
+// session writes a session of a road ride: 1h02m, 30.5 km
+func (b *fitBuilder) session(local uint8) {
+	b.define(local, mesgSession, false, sessionFields)
+	b.data(local, le32(fitTime(rideStart)), []byte{2}, []byte{7}, le32(3720500), le32(3050000))
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		build func(b *fitBuilder)
+		want  Info
+	}{
+		{"session", func(b *fitBuilder) {
+			b.define(0, mesgFileID, false, fileIDFields)
+			b.data(0, le32(fitTime(rideStart.Add(-time.Minute))))
+			b.session(1)
+		}, Info{StartTime: rideStart, Sport: 2, SubSport: 7, Duration: 3720.5, Distance: 30500}},
+		{"big-endian session", func(b *fitBuilder) {
+			b.define(0, mesgSession, true, sessionFields)
+			b.data(0, be32(fitTime(rideStart)), []byte{1}, []byte{3}, be32(1800000), be32(500000))
+		}, Info{StartTime: rideStart, Sport: 1, SubSport: 3, Duration: 1800, Distance: 5000}},
+		{"local time offset", func(b *fitBuilder) {
+			b.session(0)
+			b.define(1, mesgActivity, false, activityFields)
+			end := fitTime(rideStart) + 3800
+			b.data(1, le32(end), le32(end+3600))
+		}, Info{StartTime: rideStart, Sport: 2, SubSport: 7, Duration: 3720.5, Distance: 30500,
+			LocalOffset: time.Hour, HasLocalOffset: true}},
+		{"compressed timestamp records", func(b *fitBuilder) {
+			b.define(0, 20, false, recordFields)
+			b.compressed(0, 3, []byte{120}, le32(100))
+			b.compressed(0, 4, []byte{121}, le32(200))
+			b.session(1)
+		}, Info{StartTime: rideStart, Sport: 2, SubSport: 7, Duration: 3720.5, Distance: 30500}},
+		{"developer fields", func(b *fitBuilder) {
+			b.define(0, 20, false, recordFields, 2, 3)
+			b.data(0, []byte{120}, le32(100), []byte{0xAA, 0xAA}, []byte{0xBB, 0xBB, 0xBB})
+			b.session(1)
+		}, Info{StartTime: rideStart, Sport: 2, SubSport: 7, Duration: 3720.5, Distance: 30500}},
+		{"redefined local type", func(b *fitBuilder) {
+			b.define(0, 20, false, recordFields)
+			b.data(0, []byte{120}, le32(100))
+			b.session(0)
+		}, Info{StartTime: rideStart, Sport: 2, SubSport: 7, Duration: 3720.5, Distance: 30500}},
+		{"sport message", func(b *fitBuilder) {
+			b.define(0, mesgSport, false, []field{{0, 1}, {1, 1}})
+			b.data(0, []byte{5}, []byte{17})
+			b.define(1, mesgSession, false, []field{{2, 4}, {5, 1}, {6, 1}})
+			b.data(1, le32(fitTime(rideStart)), []byte{0xFF}, []byte{0xFF})
+		}, Info{StartTime: rideStart, Sport: 5, SubSport: 17}},
+		{"first session only", func(b *fitBuilder) {
+			b.session(0)
+			b.data(0, le32(fitTime(rideStart.Add(time.Hour))), []byte{2}, []byte{7}, le32(0xFFFFFFFF), le32(0xFFFFFFFF))
+		}, Info{StartTime: rideStart, Sport: 2, SubSport: 7, Duration: 3720.5, Distance: 30500}},
+		{"file creation time", func(b *fitBuilder) {
+			b.define(0, mesgFileID, false, fileIDFields)
+			b.data(0, le32(fitTime(rideStart)))
+		}, Info{StartTime: rideStart}},
+	} {
+		var b fitBuilder
+		test.build(&b)
+		info, err := Parse(b.file())
+		if err != nil {
+			t.Errorf("%s: Parse: %v", test.name, err)
+			continue
+		}
+		if !info.StartTime.Equal(test.want.StartTime) {
+			t.Errorf("%s: StartTime = %s, want %s", test.name, info.StartTime, test.want.StartTime)
+		}
+		info.StartTime = test.want.StartTime
+		if *info != test.want {
+			t.Errorf("%s: Parse = %+v, want %+v", test.name, *info, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var valid fitBuilder
+	valid.session(0)
+
+	truncated := valid.file()
+	truncated = truncated[:len(truncated)-10]
+
+	var undefined fitBuilder
+	undefined.data(3, le32(1))
+
+	var undefinedCompressed fitBuilder
+	undefinedCompressed.compressed(2, 0, le32(1))
+
+	var noStart fitBuilder
+	noStart.define(0, 20, false, recordFields)
+	noStart.data(0, []byte{120}, le32(100))
+
+	notFIT := valid.file()
+	copy(notFIT[8:12], ".ZIP")
+
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("short")},
+		{"not a FIT file", notFIT},
+		{"header size too small", append([]byte{8}, valid.file()[1:]...)},
+		{"truncated", truncated},
+		{"data message without definition", undefined.file()},
+		{"compressed message without definition", undefinedCompressed.file()},
+		{"no start time", noStart.file()},
+	} {
+		if _, err := Parse(test.data); err == nil {
+			t.Errorf("%s: Parse succeeded", test.name)
+		}
+	}
+
+	if _, err := Parse([]byte("PK\x03\x04 a ZIP file, not a FIT")); !errors.Is(err, ErrNotFIT) {
+		t.Errorf("Parse of a ZIP file: error = %v, want ErrNotFIT", err)
+	}
+}
+
+// TestParseCorrupt checks that cut and garbled records never make Parse
+// panic, whatever it makes of them
+func TestParseCorrupt(t *testing.T) {
+	var b fitBuilder
+	b.define(0, 20, false, recordFields, 2)
+	b.compressed(0, 1, []byte{120}, le32(100), []byte{0, 0})
+	b.session(1)
+	b.define(2, mesgActivity, true, activityFields)
+	b.data(2, be32(fitTime(rideStart)+4000), be32(fitTime(rideStart)+7600))
+	records := b.records.Bytes()
+
+	parse := func(name string, records []byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("%s: Parse panicked: %v", name, r)
+			}
+		}()
+		var cut fitBuilder
+		cut.records.Write(records)
+		Parse(cut.file())
+	}
+
+	// Records cut at every length, with a header that agrees
+	for n := range records {
+		parse("cut", records[:n])
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		garbled := bytes.Clone(records)
+		for j := 0; j < 1+rng.Intn(4); j++ {
+			garbled[rng.Intn(len(garbled))] = byte(rng.Intn(256))
+		}
+		parse("garbled", garbled)
+
+		random := make([]byte, rng.Intn(64))
+		rng.Read(random)
+		parse("random", random)
+	}
+}
+
+func TestTypeKey(t *testing.T) {
+	for _, test := range []struct {
+		sport, subSport uint8
+		want            string
+	}{
+		{2, 7, "road_biking"},
+		{2, 0, "cycling"},
+		{2, 200, "cycling"},
+		{5, 17, "lap_swimming"},
+		{4, 20, "strength_training"},
+		{1, 1, "treadmill_running"},
+		{200, 0, "other"},
+	} {
+		info := Info{Sport: test.sport, SubSport: test.subSport}
+		if got := info.TypeKey(); got != test.want {
+			t.Errorf("TypeKey(%d, %d) = %q, want %q", test.sport, test.subSport, got, test.want)
+		}
+	}
+}
+
+func TestLocalStartTime(t *testing.T) {
+	info := Info{StartTime: rideStart}
+	tokyo := time.FixedZone("JST", 9*3600)
+	if got := info.LocalStartTime(tokyo); got.Hour() != 15 {
+		t.Errorf("LocalStartTime without offset = %s, want it in the given zone", got)
+	}
+
+	info.LocalOffset, info.HasLocalOffset = time.Hour, true
+	if got := info.LocalStartTime(tokyo); got.Hour() != 7 || !got.Equal(rideStart) {
+		t.Errorf("LocalStartTime with a recorded offset = %s, want 07:15 in the device zone", got)
+	}
+}
@@ -0,0 +1,39 @@
+package fit
+
+// sportKeys maps FIT sports to Garmin Connect activity type keys
+var sportKeys = map[uint8]string{
+	0:  "other",
+	1:  "running",
+	2:  "cycling",
+	4:  "fitness_equipment",
+	5:  "swimming",
+	10: "training",
+	11: "walking",
+	17: "hiking",
+	21: "e_bike_fitness",
+}
+
+// subSportKeys maps FIT sport and sub-sport pairs to the more specific
+// Garmin Connect activity type keys
+var subSportKeys = map[[2]uint8]string{
+	{1, 1}:   "treadmill_running",
+	{1, 2}:   "street_running",
+	{1, 3}:   "trail_running",
+	{1, 4}:   "track_running",
+	{1, 45}:  "indoor_running",
+	{1, 58}:  "virtual_run",
+	{2, 6}:   "indoor_cycling",
+	{2, 7}:   "road_biking",
+	{2, 8}:   "mountain_biking",
+	{2, 11}:  "cyclocross",
+	{2, 13}:  "track_cycling",
+	{2, 46}:  "gravel_cycling",
+	{2, 58}:  "virtual_ride",
+	{4, 1}:   "treadmill_running",
+	{4, 6}:   "indoor_cycling",
+	{4, 20}:  "strength_training",
+	{5, 17}:  "lap_swimming",
+	{5, 18}:  "open_water_swimming",
+	{10, 20}: "strength_training",
+	{11, 1}:  "indoor_walking",
+}
@@ -0,0 +1,159 @@
+package garmin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrReauthRequired is wrapped by login errors that only an interactive login
+// can solve: the account uses multi-factor authentication and its saved
+// session is missing or was revoked
+var ErrReauthRequired = errors.New("Garmin needs an interactive login, run garmin-to-ido garmin-login")
+
+// codeReauthRequired is the error code the script answers with when
+// ErrReauthRequired applies
+const codeReauthRequired = "reauth_required"
+
+// ScriptError is returned when the embedded Python script answers a request
+// with an error, or exits
+type ScriptError struct {
+	// ExitCode is the exit code of the script if it exited, 0 if it is still
+	// running
+	ExitCode int
+	// Message is the error answered by the script, or the end of its error
+	// output if it exited
+	Message string
+	// Code classifies the error answered by the script, if it did
+	Code string
+	// Exceptions are the class names of the Python exception behind the
+	// error and of the exceptions that caused it
+	Exceptions []string
+}
+
+func (e *ScriptError) Error() string {
+	if e.Code == codeReauthRequired {
+		return fmt.Sprintf("%v: %s", ErrReauthRequired, e.Message)
+	}
+	return fmt.Sprintf("Python script failed: %s", e.Message)
+}
+
+// Unwrap returns ErrReauthRequired if the script asked for an interactive
+// login
+func (e *ScriptError) Unwrap() error {
+	if e.Code == codeReauthRequired {
+		return ErrReauthRequired
+	}
+	return nil
+}
+
+// transientExceptions are the Python exceptions raised for network failures
+// and rate limiting, by requests, urllib3, http.client and garminconnect
+var transientExceptions = map[string]bool{
+	"ConnectionError":                   true,
+	"ConnectionResetError":              true,
+	"ConnectionRefusedError":            true,
+	"ConnectionAbortedError":            true,
+	"ConnectTimeout":                    true,
+	"ReadTimeout":                       true,
+	"Timeout":                           true,
+	"TimeoutError":                      true,
+	"ChunkedEncodingError":              true,
+	"ProtocolError":                     true,
+	"RemoteDisconnected":                true,
+	"GarminConnectTooManyRequestsError": true,
+}
+
+// transientStatuses are the HTTP errors, as requests words them in its
+// messages, that point to a temporary problem on Garmin's side
+var transientStatuses = []string{
+	"Too Many Requests",
+	"Server Error",
+	"Service Unavailable",
+	"Bad Gateway",
+	"Gateway Timeout",
+}
+
+// Transient reports whether sending the request again may succeed. Login
+// failures are never transient unless Garmin is rate limiting.
+func (e *ScriptError) Transient() bool {
+	// The worker died in the middle of the request, the next call starts
+	// a new one
+	if e.ExitCode != 0 {
+		return true
+	}
+	for _, name := range e.Exceptions {
+		if transientExceptions[name] {
+			return true
+		}
+	}
+	for _, status := range transientStatuses {
+		if strings.Contains(e.Message, status) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthFailure reports whether the script could not log into Garmin Connect
+func (e *ScriptError) AuthFailure() bool {
+	if e.Code == codeReauthRequired {
+		return true
+	}
+	return strings.Contains(e.Message, "Login failed") && !strings.Contains(e.Message, "Too Many Requests")
+}
+
+// HTTPError is returned when Garmin answers a request of the native client
+// with an unexpected status code
+type HTTPError struct {
+	Op         string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	body := e.Body
+	if len(body) > 200 {
+		body = body[:200] + "..."
+	}
+	return fmt.Sprintf("Garmin %s failed: %d %s", e.Op, e.StatusCode, strings.TrimSpace(body))
+}
+
+// Transient reports whether the request may succeed later: rate limiting and
+// server errors
+func (e *HTTPError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// AuthFailure reports whether Garmin rejected the credentials or the tokens
+func (e *HTTPError) AuthFailure() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// AuthError is returned when the Garmin SSO rejects the login
+type AuthError struct {
+	Reason string
+	// ReauthRequired is set when only an interactive login can succeed
+	ReauthRequired bool
+}
+
+func (e *AuthError) Error() string {
+	if e.ReauthRequired {
+		return fmt.Sprintf("%v: %s", ErrReauthRequired, e.Reason)
+	}
+	return "Garmin login failed: " + e.Reason
+}
+
+// Unwrap returns ErrReauthRequired if only an interactive login can succeed
+func (e *AuthError) Unwrap() error {
+	if e.ReauthRequired {
+		return ErrReauthRequired
+	}
+	return nil
+}
+
+// AuthFailure always reports true
+func (e *AuthError) AuthFailure() bool {
+	return true
+}
@@ -0,0 +1,65 @@
+package garmin
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Format is a download format supported by Garmin Connect
+type Format string
+
+const (
+	// FormatFIT downloads the original file, a ZIP archive holding the FIT file
+	FormatFIT Format = "FIT"
+	FormatGPX Format = "GPX"
+	FormatTCX Format = "TCX"
+)
+
+// ParseFormat parses a download format name, case-insensitively
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToUpper(strings.TrimSpace(name))); format {
+	case FormatFIT, FormatGPX, FormatTCX:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown format %q (use fit, gpx or tcx)", name)
+}
+
+// Extension returns the file extension of the format, e.g. ".gpx"
+func (f Format) Extension() string {
+	return "." + strings.ToLower(string(f))
+}
+
+// ExtractFIT returns the name and content of the FIT file in a ZIP archive
+// downloaded in FormatFIT
+func ExtractFIT(zipData []byte) (string, []byte, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read ZIP: %w", err)
+	}
+
+	for _, file := range zipReader.File {
+		if !strings.EqualFold(filepath.Ext(file.Name), ".fit") {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open FIT file in ZIP: %w", err)
+		}
+		fitData, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read FIT file: %w", err)
+		}
+		if len(fitData) == 0 {
+			break
+		}
+		return file.Name, fitData, nil
+	}
+
+	return "", nil, fmt.Errorf("no FIT file found in ZIP")
+}
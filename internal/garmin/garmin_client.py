@@ -1,43 +1,151 @@
 #!/usr/bin/env python3
 """
 Python wrapper for Garmin Connect API using garminconnect library.
-This script can be called from Go to handle authentication and data fetching.
+This script runs as a worker for the Go client: it reads one JSON request per
+line on stdin and answers each with one JSON line on stdout, keeping a single
+Garmin session for all requests.
+
+    {"id": 1, "method": "login", "params": {"username": "...", "password": "...", "mfa": false, "fresh": false}}
+    {"id": 2, "method": "get_activities", "params": {"start": "2024-05-01", "end": "2024-05-02"}}
+    {"id": 3, "method": "download_activity", "params": {"activity_id": 123, "format": "FIT"}}
+
+Credentials only travel on stdin, never on the command line where any user
+could read them with ps. Responses are {"id": 1, "result": ...} or {"id": 1, "error": "message"}.
+Downloaded files are base64 encoded. Errors that need an interactive login
+also have "code": "reauth_required"; other errors list the class names of
+their exception chain in "exceptions".
+
+An account with multi-factor authentication can only log in with "mfa": true:
+login then answers "mfa_required", and {"method": "mfa", "params": {"code":
+"123456"}} finishes it. Otherwise, it needs a saved session.
+
+With --token-dir, the session tokens are saved there and reused by the next
+worker, which then does not log in with the password. "fresh": true skips the
+saved session; it is only replaced once the new login succeeds.
 """
 
+import os
 import sys
 import json
+import base64
+import tempfile
 import argparse
-from datetime import datetime, timedelta
+from datetime import datetime
 from garminconnect import Garmin
 
 
-def login(username, password):
-    """Login to Garmin Connect and return client."""
+TOKEN_FILES = ("oauth1_token.json", "oauth2_token.json")
+
+
+class ReauthRequired(Exception):
+    """Raised when only an interactive login (with an MFA code) can succeed."""
+
+
+def login(username, password, token_dir=None, mfa=False, fresh=False):
+    """Login to Garmin Connect and return client, and the state to resume the
+    login with if an MFA code is needed.
+
+    The session saved in token_dir is tried first; garth refreshes its OAuth2
+    token if it has expired. Without mfa, accounts with multi-factor
+    authentication raise ReauthRequired rather than waiting for a code. With
+    fresh, the saved session is not tried but kept until the login succeeds.
+    """
+    if not fresh and token_dir and os.path.exists(os.path.join(token_dir, TOKEN_FILES[0])):
+        try:
+            client = Garmin(username, password)
+            client.login(token_dir)
+            save_tokens(client, token_dir)
+            return client, None
+        except Exception as e:
+            # A password login would only send yet another MFA code
+            if saved_with_mfa(token_dir) and not mfa:
+                raise ReauthRequired(f"the saved Garmin session was rejected ({e})") from e
+            print(f"Saved Garmin session rejected, logging in again: {e}", file=sys.stderr)
+
     try:
-        client = Garmin(username, password)
-        client.login()
-        return client
+        client = Garmin(username, password, return_on_mfa=True)
+        result = client.login()
     except Exception as e:
-        print(f"Login failed: {e}", file=sys.stderr)
-        sys.exit(1)
+        raise RuntimeError(f"Login failed: {e}") from e
 
+    if isinstance(result, tuple) and result[0] == "needs_mfa":
+        if not mfa:
+            raise ReauthRequired("the account requires multi-factor authentication")
+        return client, result[1]
+    save_tokens(client, token_dir)
+    return client, None
 
-def get_activities(client, date_str):
-    """Get activities for a specific date."""
+
+def saved_with_mfa(token_dir):
+    """Tell whether the saved session was obtained with an MFA code."""
+    try:
+        with open(os.path.join(token_dir, TOKEN_FILES[0])) as f:
+            return bool(json.load(f).get("mfa_token"))
+    except (OSError, ValueError):
+        return False
+
+
+def save_tokens(client, token_dir):
+    """Save the session tokens, readable by the owner only. They are dumped
+    next to the saved ones first, so a failed write leaves those intact."""
+    if not token_dir:
+        return
+    with tempfile.TemporaryDirectory(dir=token_dir) as tmp:
+        client.garth.dump(tmp)
+        for name in TOKEN_FILES:
+            path = os.path.join(tmp, name)
+            if os.path.exists(path):
+                os.chmod(path, 0o600)
+                os.replace(path, os.path.join(token_dir, name))
+
+
+def get_activities(client, start_str, end_str):
+    """Get activities between two dates (inclusive)."""
     try:
-        # Parse date
-        date = datetime.strptime(date_str, "%Y-%m-%d")
+        # Validate dates
+        start = datetime.strptime(start_str, "%Y-%m-%d")
+        end = datetime.strptime(end_str, "%Y-%m-%d")
+        if end < start:
+            raise ValueError(f"end date {end_str} is before start date {start_str}")
 
-        # Get activities for the date range
+        # Get activities for the whole date range in a single call
         activities = client.get_activities_by_date(
-            date.strftime("%Y-%m-%d"),
-            (date + timedelta(days=1)).strftime("%Y-%m-%d")
+            start.strftime("%Y-%m-%d"),
+            end.strftime("%Y-%m-%d")
         )
 
         return activities
     except Exception as e:
-        print(f"Failed to get activities: {e}", file=sys.stderr)
-        sys.exit(1)
+        raise RuntimeError(f"Failed to get activities: {e}") from e
+
+
+def start_times(activity):
+    """Return the start time as ISO8601 in UTC and in the activity's local time.
+
+    Garmin reports both as "YYYY-MM-DD HH:MM:SS" without a zone; the offset of
+    the local time is the difference between the two.
+    """
+    fmt = "%Y-%m-%d %H:%M:%S"
+    gmt_str = activity.get("startTimeGMT")
+    local_str = activity.get("startTimeLocal")
+    try:
+        gmt = datetime.strptime(gmt_str, fmt) if gmt_str else None
+        local = datetime.strptime(local_str, fmt) if local_str else None
+    except ValueError:
+        return None, None
+
+    if gmt is None:
+        return None, None
+    start_gmt = gmt.strftime("%Y-%m-%dT%H:%M:%SZ")
+    if local is None:
+        return start_gmt, None
+
+    # Offsets are whole minutes
+    offset = round((local - gmt).total_seconds() / 60)
+    sign = "+" if offset >= 0 else "-"
+    hours, minutes = divmod(abs(offset), 60)
+    start_local = local.strftime("%Y-%m-%dT%H:%M:%S") + f"{sign}{hours:02d}:{minutes:02d}"
+    return start_gmt, start_local
 
 
 def download_activity(client, activity_id, format="FIT"):
@@ -54,72 +162,135 @@ def download_activity(client, activity_id, format="FIT"):
             data = client.download_activity(activity_id, dl_fmt=client.ActivityDownloadFormat.ORIGINAL)
         return data
     except Exception as e:
-        print(f"Failed to download activity: {e}", file=sys.stderr)
-        sys.exit(1)
+        raise RuntimeError(f"Failed to download activity: {e}") from e
+
+
+def workout_name(client, state, activity):
+    """Return the name of the workout the activity was recorded from, or an
+    empty string. Names are cached, the same workouts come back often."""
+    workout_id = activity.get("workoutId")
+    if not workout_id:
+        return ""
+    names = state.setdefault("workouts", {})
+    if workout_id not in names:
+        try:
+            names[workout_id] = client.get_workout_by_id(workout_id).get("workoutName") or ""
+        except Exception as e:
+            # The name is only used to name activities
+            print(f"Could not get Garmin workout {workout_id}: {e}", file=sys.stderr)
+            names[workout_id] = ""
+    return names[workout_id]
+
+
+def activity_json(activity, workout=""):
+    """Return the fields of an activity used on the Go side."""
+    start_gmt, start_local = start_times(activity)
+    return {
+        "activityId": activity.get("activityId"),
+        "activityName": activity.get("activityName"),
+        "activityType": activity.get("activityType", {}).get("typeKey"),
+        "workoutName": workout,
+        "startTimeGMT": start_gmt,
+        "startTimeLocal": start_local,
+        "distance": activity.get("distance", 0),
+        "duration": activity.get("duration", 0),
+        "averageSpeed": activity.get("averageSpeed", 0),
+        "calories": activity.get("calories", 0)
+    }
+
+
+def exception_names(e):
+    """Return the class names of an exception and of the ones that caused it,
+    so the Go side can tell network failures from other errors."""
+    names = []
+    seen = set()
+    while e is not None and id(e) not in seen:
+        seen.add(id(e))
+        names.append(type(e).__name__)
+        e = e.__cause__ or e.__context__
+    return names
+
+
+def handle(state, token_dir, method, params):
+    """Run one request and return its result."""
+    if method == "login":
+        client, mfa_state = login(params["username"], params["password"], token_dir,
+                                 mfa=params.get("mfa", False), fresh=params.get("fresh", False))
+        if mfa_state is not None:
+            state["mfa"] = (client, mfa_state)
+            return "mfa_required"
+        state["client"] = client
+        state["oauth2"] = client.garth.oauth2_token
+        return "ok"
+    if method == "mfa":
+        if "mfa" not in state:
+            raise RuntimeError("no login is waiting for an MFA code")
+        client, mfa_state = state.pop("mfa")
+        try:
+            client.resume_login(mfa_state, params["code"])
+        except Exception as e:
+            raise RuntimeError(f"Login failed: {e}") from e
+        save_tokens(client, token_dir)
+        state["client"] = client
+        state["oauth2"] = client.garth.oauth2_token
+        return "ok"
+
+    client = state.get("client")
+    if client is None:
+        raise RuntimeError("not logged in")
+
+    if method == "get_activities":
+        # All activities are returned, filtering is done on the Go side
+        activities = get_activities(client, params["start"], params["end"])
+        result = [activity_json(activity, workout_name(client, state, activity)) for activity in activities]
+    elif method == "download_activity":
+        data = download_activity(client, params["activity_id"], format=params.get("format", "FIT"))
+        result = base64.b64encode(data).decode("ascii")
+    else:
+        raise ValueError(f"unknown method {method!r}")
+
+    # garth refreshes the OAuth2 token when it expires
+    if client.garth.oauth2_token is not state.get("oauth2"):
+        state["oauth2"] = client.garth.oauth2_token
+        save_tokens(client, token_dir)
+    return result
+
+
+def serve(token_dir=None):
+    """Answer requests until stdin is closed."""
+    out = sys.stdout
+    # Anything the library prints must not corrupt the responses
+    sys.stdout = sys.stderr
+
+    state = {}
+    while True:
+        line = sys.stdin.readline()
+        if not line:
+            return
+        if not line.strip():
+            continue
+
+        request_id = None
+        try:
+            request = json.loads(line)
+            request_id = request.get("id")
+            result = handle(state, token_dir, request.get("method"), request.get("params") or {})
+            response = {"id": request_id, "result": result}
+        except ReauthRequired as e:
+            response = {"id": request_id, "error": str(e), "code": "reauth_required"}
+        except Exception as e:
+            response = {"id": request_id, "error": str(e), "exceptions": exception_names(e)}
+
+        out.write(json.dumps(response) + "\n")
+        out.flush()
 
 
 def main():
-    parser = argparse.ArgumentParser(description="Garmin Connect API wrapper")
-    parser.add_argument("--username", required=True, help="Garmin username")
-    parser.add_argument("--password", required=True, help="Garmin password")
-    parser.add_argument("--command", required=True, choices=["get-activities", "download-activity"],
-                        help="Command to execute")
-    parser.add_argument("--date", help="Date for get-activities (YYYY-MM-DD)")
-    parser.add_argument("--activity-id", type=int, help="Activity ID for download-activity")
-    parser.add_argument("--output", help="Output file for download-activity")
-    parser.add_argument("--format", default="FIT", choices=["FIT", "GPX", "TCX"],
-                        help="Download format for activity (default: FIT)")
+    parser = argparse.ArgumentParser(description="Garmin Connect API worker")
+    parser.add_argument("--token-dir", help="Directory of the saved session tokens")
 
     args = parser.parse_args()
-
-    # Login
-    client = login(args.username, args.password)
-
-    if args.command == "get-activities":
-        if not args.date:
-            print("--date is required for get-activities", file=sys.stderr)
-            sys.exit(1)
-
-        activities = get_activities(client, args.date)
-
-        # Filter for cycling activities and output as JSON
-        bike_activities = []
-        for activity in activities:
-            activity_type = activity.get("activityType", {}).get("typeKey", "").lower()
-            if "cycling" in activity_type or "bike" in activity_type or "biking" in activity_type:
-                # Parse and reformat startTimeLocal to ISO8601
-                start_time = activity.get("startTimeLocal")
-                if start_time and " " in start_time:
-                    # Convert "YYYY-MM-DD HH:MM:SS" to "YYYY-MM-DDTHH:MM:SSZ"
-                    start_time = start_time.replace(" ", "T") + "Z"
-
-                bike_activities.append({
-                    "activityId": activity.get("activityId"),
-                    "activityName": activity.get("activityName"),
-                    "activityType": activity.get("activityType", {}).get("typeKey"),
-                    "startTimeLocal": start_time,
-                    "distance": activity.get("distance", 0),
-                    "duration": activity.get("duration", 0),
-                    "averageSpeed": activity.get("averageSpeed", 0),
-                    "calories": activity.get("calories", 0)
-                })
-
-        print(json.dumps(bike_activities, indent=2))
-
-    elif args.command == "download-activity":
-        if not args.activity_id:
-            print("--activity-id is required for download-activity", file=sys.stderr)
-            sys.exit(1)
-
-        activity_data = download_activity(client, args.activity_id, format=args.format)
-
-        if args.output:
-            with open(args.output, "wb") as f:
-                f.write(activity_data)
-            print(f"Activity downloaded to {args.output}")
-        else:
-            # Output to stdout
-            sys.stdout.buffer.write(activity_data)
+    serve(args.token_dir)
 
 
 if __name__ == "__main__":
@@ -6,7 +6,14 @@ import "time"
 type GarminClient interface {
 	Login() error
 	GetActivities(date time.Time) ([]Activity, error)
+	GetActivitiesInRange(start, end time.Time) ([]Activity, error)
 	GetBikeActivities(date time.Time) ([]Activity, error)
-	DownloadActivity(activityID int64) ([]byte, error)
+	// DownloadActivity downloads an activity file. FormatFIT yields a ZIP
+	// archive, see ExtractFIT.
+	DownloadActivity(activityID int64, format Format) ([]byte, error)
 	Logout() error
 }
+
+// MFAPrompt asks the user for the multi-factor authentication code Garmin
+// just sent
+type MFAPrompt func() (string, error)
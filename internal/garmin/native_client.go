@@ -0,0 +1,420 @@
+package garmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dghubble/oauth1"
+)
+
+// activityPageSize is the number of activities asked per search request
+const activityPageSize = 50
+
+// NativeClient talks to Garmin Connect directly, the way the mobile app does:
+// SSO sign-in, then an OAuth1 token exchanged for OAuth2 bearer tokens. It
+// needs neither Python nor garminconnect.
+type NativeClient struct {
+	username  string
+	password  string
+	consumer  consumer
+	http      *http.Client
+	store     *TokenStore
+	promptMFA MFAPrompt
+	fresh     bool
+
+	// mu guards the tokens, refreshed by concurrent downloads
+	mu     gosync.Mutex
+	oauth  *oauth1.Config
+	oauth1 *OAuth1Token
+	oauth2 *OAuth2Token
+
+	// workoutNames caches the names of the workouts activities come from
+	workoutMu    gosync.Mutex
+	workoutNames map[int64]string
+
+	// loggedInAt is the time of the last successful login, in Unix nanoseconds
+	loggedInAt atomic.Int64
+}
+
+var _ GarminClient = (*NativeClient)(nil)
+
+// NewNativeClient creates a native Garmin Connect client
+func NewNativeClient(username, password string) *NativeClient {
+	return &NativeClient{
+		username: username,
+		password: password,
+		consumer: mobileConsumer,
+		http:     &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// SetConsumer replaces the OAuth1 consumer of the Garmin Connect mobile app,
+// in case Garmin changes it
+func (c *NativeClient) SetConsumer(key, secret string) {
+	c.consumer = consumer{Key: key, Secret: secret}
+}
+
+// SetTokenStore makes the client reuse the session saved in store, and save
+// its tokens there
+func (c *NativeClient) SetTokenStore(store *TokenStore) {
+	c.store = store
+}
+
+// SetMFAPrompt lets Login ask for an MFA code. Without a prompt, accounts
+// with multi-factor authentication fail with ErrReauthRequired unless the
+// token store holds a usable session.
+func (c *NativeClient) SetMFAPrompt(prompt MFAPrompt) {
+	c.promptMFA = prompt
+}
+
+// SetFreshLogin makes Login sign in with the password even if the token
+// store holds a session. The saved session is only replaced once the login
+// succeeds.
+func (c *NativeClient) SetFreshLogin(fresh bool) {
+	c.fresh = fresh
+}
+
+// Login obtains the API tokens: from the token store if it holds a usable
+// session, otherwise by signing in through Garmin SSO
+func (c *NativeClient) Login() error {
+	client := newSSOClient()
+	config := oauth1.NewConfig(c.consumer.Key, c.consumer.Secret)
+	ctx := context.WithValue(context.Background(), oauth1.HTTPClient, client)
+
+	token1, token2, err := c.storedSession(ctx, config)
+	if errors.Is(err, ErrReauthRequired) {
+		return err
+	}
+	if err != nil {
+		ticket, err := ssoLogin(client, c.username, c.password, c.promptMFA)
+		if err != nil {
+			return err
+		}
+		if token1, err = preauthorize(ctx, config, ticket); err != nil {
+			return err
+		}
+		if token2, err = exchange(ctx, config, token1); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.oauth, c.oauth1, c.oauth2 = config, token1, token2
+	err = c.saveTokens()
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	c.loggedInAt.Store(time.Now().UnixNano())
+	return nil
+}
+
+// storedSession returns the tokens of the token store, with the OAuth2 token
+// exchanged again if it has expired. It fails if there is no store, a fresh
+// login is asked for or there is no usable session in the store, with ErrReauthRequired if that session came from an
+// MFA login and there is no prompt: signing in again would only send yet
+// another MFA code.
+func (c *NativeClient) storedSession(ctx context.Context, config *oauth1.Config) (*OAuth1Token, *OAuth2Token, error) {
+	if c.store == nil || c.fresh {
+		return nil, nil, os.ErrNotExist
+	}
+	token1, token2, err := c.store.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if token2.expired() {
+		if token2, err = exchange(ctx, config, token1); err != nil {
+			if token1.MFAToken != "" && c.promptMFA == nil {
+				return nil, nil, &AuthError{Reason: fmt.Sprintf("the saved Garmin session was rejected (%v)", err), ReauthRequired: true}
+			}
+			return nil, nil, err
+		}
+	}
+	return token1, token2, nil
+}
+
+// saveTokens saves the tokens in the token store, if any. The caller must
+// hold mu.
+func (c *NativeClient) saveTokens() error {
+	if c.store == nil {
+		return nil
+	}
+	if err := c.store.Save(c.oauth1, c.oauth2); err != nil {
+		return fmt.Errorf("failed to save Garmin tokens: %w", err)
+	}
+	return nil
+}
+
+// LoggedInAt returns the time of the last successful login, zero before
+func (c *NativeClient) LoggedInAt() time.Time {
+	if nanos := c.loggedInAt.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// GetActivities retrieves activities of all types for a specific date
+func (c *NativeClient) GetActivities(date time.Time) ([]Activity, error) {
+	return c.GetActivitiesInRange(date, date)
+}
+
+// searchActivity is an activity as returned by the activity search
+type searchActivity struct {
+	ActivityID   int64  `json:"activityId"`
+	ActivityName string `json:"activityName"`
+	ActivityType struct {
+		TypeKey string `json:"typeKey"`
+	} `json:"activityType"`
+	WorkoutID      int64   `json:"workoutId"`
+	StartTimeGMT   string  `json:"startTimeGMT"`   // "2006-01-02 15:04:05"
+	StartTimeLocal string  `json:"startTimeLocal"` // "2006-01-02 15:04:05"
+	Distance       float64 `json:"distance"`
+	Duration       float64 `json:"duration"`
+	AverageSpeed   float64 `json:"averageSpeed"`
+	Calories       float64 `json:"calories"`
+}
+
+// GetActivitiesInRange retrieves activities between two dates (inclusive),
+// as Garmin dates them (in the time zone they were recorded in)
+func (c *NativeClient) GetActivitiesInRange(start, end time.Time) ([]Activity, error) {
+	var activities []Activity
+	for offset := 0; ; offset += activityPageSize {
+		query := url.Values{
+			"startDate": {start.Format("2006-01-02")},
+			"endDate":   {end.Format("2006-01-02")},
+			"start":     {strconv.Itoa(offset)},
+			"limit":     {strconv.Itoa(activityPageSize)},
+		}
+		data, err := c.get("activity search", "/activitylist-service/activities/search/activities?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		var page []searchActivity
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse activities JSON: %w", err)
+		}
+		for _, found := range page {
+			activity := found.activity()
+			if found.WorkoutID != 0 {
+				activity.WorkoutName = c.workoutName(found.WorkoutID)
+			}
+			activities = append(activities, activity)
+		}
+		if len(page) < activityPageSize {
+			return activities, nil
+		}
+	}
+}
+
+// workoutName returns the name of a workout, or an empty string if it cannot
+// be read: the name is only used to name activities
+func (c *NativeClient) workoutName(workoutID int64) string {
+	c.workoutMu.Lock()
+	defer c.workoutMu.Unlock()
+	if name, ok := c.workoutNames[workoutID]; ok {
+		return name
+	}
+
+	var workout struct {
+		WorkoutName string `json:"workoutName"`
+	}
+	data, err := c.get("workout", fmt.Sprintf("/workout-service/workout/%d", workoutID))
+	if err == nil {
+		err = json.Unmarshal(data, &workout)
+	}
+	if err != nil {
+		log.Printf("Could not get Garmin workout %d: %v", workoutID, err)
+	}
+
+	if c.workoutNames == nil {
+		c.workoutNames = make(map[int64]string)
+	}
+	c.workoutNames[workoutID] = workout.WorkoutName
+	return workout.WorkoutName
+}
+
+// activity converts a search result, reading the start times like the
+// Python script does
+func (a searchActivity) activity() Activity {
+	activity := Activity{
+		ActivityID:   a.ActivityID,
+		ActivityName: a.ActivityName,
+		ActivityType: a.ActivityType.TypeKey,
+		Distance:     a.Distance,
+		Duration:     a.Duration,
+		AvgSpeed:     a.AverageSpeed,
+		Calories:     a.Calories,
+	}
+
+	// The local time has no zone: its offset is the difference with GMT
+	gmt, errGMT := time.Parse("2006-01-02 15:04:05", a.StartTimeGMT)
+	local, errLocal := time.Parse("2006-01-02 15:04:05", a.StartTimeLocal)
+	if errGMT == nil {
+		activity.StartTimeGMT = gmt
+		if errLocal == nil {
+			offset := local.Sub(gmt).Round(time.Minute)
+			activity.StartTimeLocal = gmt.In(time.FixedZone("", int(offset/time.Second)))
+		}
+	}
+	return activity.In(nil)
+}
+
+// GetBikeActivities returns the bike activities of a specific date
+func (c *NativeClient) GetBikeActivities(date time.Time) ([]Activity, error) {
+	activities, err := c.GetActivities(date)
+	if err != nil {
+		return nil, err
+	}
+
+	var bikeActivities []Activity
+	for _, activity := range activities {
+		if activity.Sport() == SportBike {
+			bikeActivities = append(bikeActivities, activity)
+		}
+	}
+	return bikeActivities, nil
+}
+
+// DownloadActivity downloads an activity in the given format. FIT files come
+// as the original ZIP archive.
+func (c *NativeClient) DownloadActivity(activityID int64, format Format) ([]byte, error) {
+	var path string
+	switch format {
+	case FormatFIT:
+		path = fmt.Sprintf("/download-service/files/activity/%d", activityID)
+	case FormatGPX:
+		path = fmt.Sprintf("/download-service/export/gpx/activity/%d", activityID)
+	case FormatTCX:
+		path = fmt.Sprintf("/download-service/export/tcx/activity/%d", activityID)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	return c.get("download", path)
+}
+
+// Logout forgets the tokens
+func (c *NativeClient) Logout() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.oauth1, c.oauth2 = nil, nil
+	return nil
+}
+
+// get sends an authenticated GET request to the Connect API. An expired
+// OAuth2 token is exchanged again first, and once more if Garmin rejects it.
+func (c *NativeClient) get(op, path string) ([]byte, error) {
+	data, err := c.getOnce(op, path, false)
+	if httpErr, ok := err.(*HTTPError); ok && httpErr.StatusCode == http.StatusUnauthorized {
+		data, err = c.getOnce(op, path, true)
+	}
+	return data, err
+}
+
+func (c *NativeClient) getOnce(op, path string, refresh bool) ([]byte, error) {
+	token, err := c.accessToken(refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", connectAPIURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", mobileUserAgent)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Garmin %s request failed: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{Op: op, StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	return data, nil
+}
+
+// accessToken returns a valid OAuth2 access token, exchanging the OAuth1
+// token again when it has expired or when refresh is set. The exchange runs
+// without holding mu, so a slow one does not block the other requests.
+func (c *NativeClient) accessToken(refresh bool) (string, error) {
+	c.mu.Lock()
+	if c.oauth1 == nil {
+		c.mu.Unlock()
+		return "", fmt.Errorf("Garmin client is not logged in")
+	}
+	if !refresh && !c.oauth2.expired() {
+		defer c.mu.Unlock()
+		return c.oauth2.AccessToken, nil
+	}
+	config, token1 := c.oauth, c.oauth1
+	c.mu.Unlock()
+
+	ctx := context.WithValue(context.Background(), oauth1.HTTPClient, c.http)
+	token, err := exchange(ctx, config, token1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.oauth1 != token1 {
+		// Logged out or in again in the meantime
+		if c.oauth1 == nil {
+			return "", fmt.Errorf("Garmin client is not logged in")
+		}
+		return c.oauth2.AccessToken, nil
+	}
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.AuthFailure() {
+			return "", c.revoked(err)
+		}
+		return "", fmt.Errorf("failed to refresh Garmin token: %w", err)
+	}
+	c.oauth2 = token
+	if err := c.saveTokens(); err != nil {
+		return "", err
+	}
+	return c.oauth2.AccessToken, nil
+}
+
+// revoked handles an OAuth1 token Garmin no longer accepts. The token store
+// is cleared so the next Login signs in again, except for a session from an
+// MFA login: only its OAuth2 token is dropped, so the next Login reports
+// ErrReauthRequired instead of sending another MFA code. The caller must
+// hold mu.
+func (c *NativeClient) revoked(cause error) error {
+	if c.oauth1.MFAToken == "" || c.promptMFA != nil {
+		err := fmt.Errorf("failed to refresh Garmin token: %w", cause)
+		if c.store != nil {
+			if clearErr := c.store.Clear(); clearErr != nil {
+				return errors.Join(err, fmt.Errorf("failed to clear Garmin token store: %w", clearErr))
+			}
+		}
+		return err
+	}
+
+	err := error(&AuthError{Reason: fmt.Sprintf("the Garmin session was revoked (%v)", cause), ReauthRequired: true})
+	if c.store != nil {
+		if saveErr := c.store.Save(c.oauth1, nil); saveErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to save Garmin tokens: %w", saveErr))
+		}
+	}
+	return err
+}
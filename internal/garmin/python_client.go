@@ -1,19 +1,31 @@
 package garmin
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	gosync "sync"
+	"sync/atomic"
 	"time"
 )
 
-// PythonClient wraps the Python garminconnect library
+// PythonClient wraps the Python garminconnect library. The embedded script
+// runs as a worker process holding one Garmin session, from Login to Logout.
 type PythonClient struct {
 	username   string
 	password   string
 	scriptPath string
+	store      *TokenStore
+	promptMFA  MFAPrompt
+	fresh      bool
+
+	// mu serializes the requests, as the worker answers one at a time
+	mu     gosync.Mutex
+	worker *pythonWorker
+
+	// loggedInAt is the time of the last successful login, in Unix nanoseconds
+	loggedInAt atomic.Int64
 }
 
 // NewPythonClient creates a new Python-based Garmin client
@@ -24,7 +36,27 @@ func NewPythonClient(username, password string) *PythonClient {
 	}
 }
 
-// Login is a no-op for Python client (login happens per request)
+// SetTokenStore makes the worker reuse the session saved in store, and save
+// its tokens there
+func (c *PythonClient) SetTokenStore(store *TokenStore) {
+	c.store = store
+}
+
+// SetMFAPrompt lets Login ask for an MFA code. Without a prompt, accounts
+// with multi-factor authentication fail with ErrReauthRequired unless the
+// token store holds a usable session.
+func (c *PythonClient) SetMFAPrompt(prompt MFAPrompt) {
+	c.promptMFA = prompt
+}
+
+// SetFreshLogin makes the worker log in with the password even if the token
+// store holds a session. The saved session is only replaced once the login
+// succeeds.
+func (c *PythonClient) SetFreshLogin(fresh bool) {
+	c.fresh = fresh
+}
+
+// Login starts the worker and logs into Garmin Connect
 func (c *PythonClient) Login() error {
 	// Extract embedded script to temp file if not already done
 	if c.scriptPath == "" {
@@ -46,74 +78,159 @@ func (c *PythonClient) Login() error {
 		return fmt.Errorf("python3 not found in PATH")
 	}
 
-	return nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.worker != nil {
+		c.worker.stop()
+		c.worker = nil
+	}
+	return c.start()
 }
 
-// GetActivities retrieves activities for a specific date using Python script
-func (c *PythonClient) GetActivities(date time.Time) ([]Activity, error) {
-	dateStr := date.Format("2006-01-02")
-
+// start starts a worker and logs it in. The caller must hold mu.
+func (c *PythonClient) start() error {
 	// Get absolute path to script
 	absScriptPath, err := filepath.Abs(c.scriptPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute script path: %w", err)
+		return fmt.Errorf("failed to get absolute script path: %w", err)
 	}
 
-	cmd := exec.Command("python3", absScriptPath,
-		"--username", c.username,
-		"--password", c.password,
-		"--command", "get-activities",
-		"--date", dateStr)
+	// Credentials are sent in the login request, not as arguments that any
+	// user can read with ps
+	var args []string
+	if c.store != nil {
+		args = append(args, "--token-dir", c.store.Dir())
+	}
+	worker, err := startWorker(absScriptPath, args...)
+	if err != nil {
+		return err
+	}
+	if err := c.login(worker); err != nil {
+		worker.stop()
+		return err
+	}
+
+	c.worker = worker
+	c.loggedInAt.Store(time.Now().UnixNano())
+	return nil
+}
+
+// LoggedInAt returns the time of the last successful login, zero before
+func (c *PythonClient) LoggedInAt() time.Time {
+	if nanos := c.loggedInAt.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// login logs a new worker in, with the MFA code if one is needed
+func (c *PythonClient) login(worker *pythonWorker) error {
+	var status string
+	params := map[string]any{
+		"username": c.username,
+		"password": c.password,
+		"mfa":      c.promptMFA != nil,
+		"fresh":    c.fresh,
+	}
+	if err := worker.call("login", params, &status); err != nil {
+		return err
+	}
+	if status != "mfa_required" {
+		return nil
+	}
 
-	output, err := cmd.Output()
+	code, err := c.promptMFA()
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return nil, fmt.Errorf("Python script failed: %s", string(exitErr.Stderr))
-		}
-		return nil, fmt.Errorf("failed to run Python script: %w", err)
+		return fmt.Errorf("failed to read the MFA code: %w", err)
 	}
+	return worker.call("mfa", map[string]any{"code": code}, nil)
+}
+
+// GetActivities retrieves activities of all types for a specific date using Python script
+func (c *PythonClient) GetActivities(date time.Time) ([]Activity, error) {
+	return c.GetActivitiesInRange(date, date)
+}
 
+// GetActivitiesInRange retrieves activities between two dates (inclusive)
+// with a single request
+func (c *PythonClient) GetActivitiesInRange(start, end time.Time) ([]Activity, error) {
 	var activities []Activity
-	if err := json.Unmarshal(output, &activities); err != nil {
-		return nil, fmt.Errorf("failed to parse activities JSON: %w", err)
+	err := c.call("get_activities", map[string]any{
+		"start": start.Format("2006-01-02"),
+		"end":   end.Format("2006-01-02"),
+	}, &activities)
+	if err != nil {
+		return nil, err
+	}
+	for i := range activities {
+		activities[i] = activities[i].In(nil)
 	}
 
 	return activities, nil
 }
 
-// GetBikeActivities returns bike activities (already filtered by Python script)
+// GetBikeActivities returns the bike activities of a specific date
 func (c *PythonClient) GetBikeActivities(date time.Time) ([]Activity, error) {
-	return c.GetActivities(date)
+	activities, err := c.GetActivities(date)
+	if err != nil {
+		return nil, err
+	}
+
+	var bikeActivities []Activity
+	for _, activity := range activities {
+		if activity.Sport() == SportBike {
+			bikeActivities = append(bikeActivities, activity)
+		}
+	}
+	return bikeActivities, nil
 }
 
-// DownloadActivity downloads activity in FIT format using Python script
-func (c *PythonClient) DownloadActivity(activityID int64) ([]byte, error) {
-	// Get absolute path to script
-	absScriptPath, err := filepath.Abs(c.scriptPath)
+// DownloadActivity downloads activity in the given format
+func (c *PythonClient) DownloadActivity(activityID int64, format Format) ([]byte, error) {
+	// The worker sends the file base64 encoded, which is how JSON decodes
+	// into a byte slice
+	var data []byte
+	err := c.call("download_activity", map[string]any{
+		"activity_id": activityID,
+		"format":      string(format),
+	}, &data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute script path: %w", err)
+		return nil, err
 	}
+	return data, nil
+}
 
-	cmd := exec.Command("python3", absScriptPath,
-		"--username", c.username,
-		"--password", c.password,
-		"--command", "download-activity",
-		"--activity-id", fmt.Sprintf("%d", activityID),
-		"--format", "FIT")
+// call sends a request to the worker. A worker that exited, e.g. after a
+// crash, is started and logged in again first.
+func (c *PythonClient) call(method string, params, result any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	output, err := cmd.Output()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return nil, fmt.Errorf("Python script failed: %s", string(exitErr.Stderr))
+	if c.worker == nil {
+		if c.scriptPath == "" {
+			return fmt.Errorf("Garmin client is not logged in")
+		}
+		if err := c.start(); err != nil {
+			return err
 		}
-		return nil, fmt.Errorf("failed to run Python script: %w", err)
 	}
 
-	return output, nil
+	err := c.worker.call(method, params, result)
+	if c.worker.stopped {
+		c.worker = nil
+	}
+	return err
 }
 
-// Logout cleans up the temporary Python script file
+// Logout stops the worker and cleans up the temporary Python script file
 func (c *PythonClient) Logout() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.worker != nil {
+		c.worker.stop()
+		c.worker = nil
+	}
 	if c.scriptPath != "" {
 		// Best effort cleanup - ignore errors
 		os.Remove(c.scriptPath)
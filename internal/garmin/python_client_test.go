@@ -0,0 +1,59 @@
+package garmin
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakePython is a python3 stand-in that records its arguments and the login
+// request, answers it, then waits for stdin to close like the worker does.
+// It only uses shell builtins, as PATH only holds its directory.
+const fakePython = `#!/bin/sh
+printf '%s\n' "$@" > "${0%/*}/args"
+IFS= read -r line
+printf '%s\n' "$line" > "${0%/*}/stdin"
+printf '{"id": 1, "result": "ok"}\n'
+while read -r line; do :; done
+`
+
+func TestPythonClientKeepsCredentialsOffCommandLine(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake python3 is a shell script")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "python3"), []byte(fakePython), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	const username, password = "athlete@example.com", "s3cret-Passw0rd"
+	client := NewPythonClient(username, password)
+	if err := client.Login(); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if err := client.Logout(); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatalf("python3 was not run: %v", err)
+	}
+	for _, secret := range []string{username, password} {
+		if strings.Contains(string(args), secret) {
+			t.Errorf("python3 arguments contain %q:\n%s", secret, args)
+		}
+	}
+
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stdin), password) {
+		t.Errorf("the login request does not carry the password: %s", stdin)
+	}
+}
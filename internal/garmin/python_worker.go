@@ -0,0 +1,202 @@
+package garmin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// stderrTailSize is how much of the worker's error output is kept for errors
+const stderrTailSize = 4096
+
+// workerCallTimeout is how long a request may take before the worker is
+// considered hung and killed
+const workerCallTimeout = 5 * time.Minute
+
+// pythonWorker is a running instance of the embedded script. It answers one
+// JSON request per line, in order, so callers must not share it concurrently.
+type pythonWorker struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *tailBuffer
+	nextID int64
+	// timeout bounds the wait for each answer
+	timeout time.Duration
+	// stopped is set once the process has exited
+	stopped bool
+}
+
+// workerRequest is one line sent to the worker
+type workerRequest struct {
+	ID     int64  `json:"id"`
+	Method string `json:"method"`
+	Params any    `json:"params,omitempty"`
+}
+
+// workerResponse is one line answered by the worker
+type workerResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+	Code   string          `json:"code"`
+	// Exceptions are the class names of the exception chain behind Error
+	Exceptions []string `json:"exceptions"`
+}
+
+// startWorker starts the script at scriptPath with the given arguments
+func startWorker(scriptPath string, args ...string) (*pythonWorker, error) {
+	cmd := exec.Command("python3", append([]string{scriptPath}, args...)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	w := &pythonWorker{
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  bufio.NewReader(stdout),
+		stderr:  &tailBuffer{max: stderrTailSize},
+		timeout: workerCallTimeout,
+	}
+	cmd.Stderr = w.stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run Python script: %w", err)
+	}
+	return w, nil
+}
+
+// call sends a request and decodes its result into result, if not nil. If
+// the worker exits or answers garbage, it is stopped and the error holds the
+// end of its error output.
+func (w *pythonWorker) call(method string, params, result any) error {
+	if w.stopped {
+		return fmt.Errorf("Python script is not running")
+	}
+
+	w.nextID++
+	line, err := json.Marshal(workerRequest{ID: w.nextID, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+	if _, err := w.stdin.Write(append(line, '\n')); err != nil {
+		return w.exited()
+	}
+
+	line, err = w.answer(method)
+	if err != nil {
+		return err
+	}
+
+	var response workerResponse
+	if err := json.Unmarshal(line, &response); err != nil || response.ID != w.nextID {
+		w.stop()
+		if len(line) > 200 {
+			line = append(line[:200], "..."...)
+		}
+		return fmt.Errorf("invalid response from Python script: %s", strings.TrimSpace(string(line)))
+	}
+	if response.Error != "" {
+		return &ScriptError{Message: response.Error, Code: response.Code, Exceptions: response.Exceptions}
+	}
+	if result != nil {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("failed to parse %s result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// answer reads the answer to a request. A worker that does not answer in
+// time is killed, with a transient error so the request is retried with a
+// new worker.
+func (w *pythonWorker) answer(method string) ([]byte, error) {
+	type read struct {
+		line []byte
+		err  error
+	}
+	done := make(chan read, 1)
+	go func() {
+		line, err := w.stdout.ReadBytes('\n')
+		done <- read{line, err}
+	}()
+
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, w.exited()
+		}
+		return r.line, nil
+	case <-timer.C:
+		w.cmd.Process.Kill()
+		<-done
+		w.stop()
+		return nil, &ScriptError{ExitCode: -1, Message: fmt.Sprintf("no answer to the %s request within %s, the Python script was killed", method, w.timeout)}
+	}
+}
+
+// exited waits for a worker that stopped answering and returns why it exited
+func (w *pythonWorker) exited() error {
+	w.stop()
+
+	message := strings.TrimSpace(w.stderr.String())
+	if message == "" {
+		message = "exited unexpectedly"
+	}
+	exitCode := w.cmd.ProcessState.ExitCode()
+	if exitCode == 0 {
+		// Exiting in the middle of a request is still a failure
+		exitCode = 1
+	}
+	return &ScriptError{ExitCode: exitCode, Message: message}
+}
+
+// stop closes the worker's input, which makes it exit, and kills it if it
+// does not exit in time
+func (w *pythonWorker) stop() {
+	if w.stopped {
+		return
+	}
+	w.stopped = true
+
+	w.stdin.Close()
+	timer := time.AfterFunc(5*time.Second, func() { w.cmd.Process.Kill() })
+	defer timer.Stop()
+	w.cmd.Wait()
+}
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	mu   gosync.Mutex
+	max  int
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+	if len(b.data) > b.max {
+		b.data = b.data[len(b.data)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
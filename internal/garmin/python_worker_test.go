@@ -0,0 +1,49 @@
+package garmin
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// hungPython is a python3 stand-in that reads requests and never answers
+const hungPython = `#!/bin/sh
+while read -r line; do :; done
+`
+
+func TestPythonWorkerKilledWhenHung(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake python3 is a shell script")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "python3"), []byte(hungPython), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	worker, err := startWorker(filepath.Join(dir, "garmin_client.py"))
+	if err != nil {
+		t.Fatalf("startWorker: %v", err)
+	}
+	worker.timeout = 100 * time.Millisecond
+
+	started := time.Now()
+	err = worker.call("get_activities", map[string]any{"start": "2024-05-01", "end": "2024-05-01"}, nil)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("call returned after %s", elapsed)
+	}
+
+	scriptErr, ok := err.(*ScriptError)
+	if !ok {
+		t.Fatalf("call error = %v, want a ScriptError", err)
+	}
+	if !scriptErr.Transient() {
+		t.Errorf("the error of a hung worker is not transient: %v", err)
+	}
+	if !worker.stopped {
+		t.Error("the hung worker was not stopped")
+	}
+}
@@ -0,0 +1,61 @@
+package garmin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sport groups Garmin activity types into the sports synced to destinations
+type Sport string
+
+const (
+	SportBike     Sport = "bike"
+	SportRun      Sport = "run"
+	SportSwim     Sport = "swim"
+	SportWalk     Sport = "walk"
+	SportStrength Sport = "strength"
+	SportOther    Sport = "other"
+)
+
+// Sports lists every sport, in display order
+var Sports = []Sport{SportBike, SportRun, SportSwim, SportWalk, SportStrength, SportOther}
+
+// JoinSports formats sports for display, separated by sep
+func JoinSports(sports []Sport, sep string) string {
+	names := make([]string, len(sports))
+	for i, sport := range sports {
+		names[i] = string(sport)
+	}
+	return strings.Join(names, sep)
+}
+
+// ParseSport parses a sport name as used in the configuration
+func ParseSport(name string) (Sport, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, sport := range Sports {
+		if string(sport) == name {
+			return sport, nil
+		}
+	}
+	return "", fmt.Errorf("unknown sport %q (use bike, run, swim, walk, strength or other)", name)
+}
+
+// SportForType returns the sport of a Garmin activity type key
+func SportForType(typeKey string) Sport {
+	typeKey = strings.ToLower(typeKey)
+	switch {
+	case strings.Contains(typeKey, "cycling"), strings.Contains(typeKey, "biking"),
+		strings.Contains(typeKey, "bike"), typeKey == "virtual_ride":
+		return SportBike
+	case strings.Contains(typeKey, "running"), typeKey == "treadmill", typeKey == "virtual_run":
+		return SportRun
+	case strings.Contains(typeKey, "swimming"):
+		return SportSwim
+	case strings.Contains(typeKey, "walking"), typeKey == "hiking":
+		return SportWalk
+	case typeKey == "strength_training":
+		return SportStrength
+	default:
+		return SportOther
+	}
+}
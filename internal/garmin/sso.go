@@ -0,0 +1,292 @@
+package garmin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dghubble/oauth1"
+)
+
+// Garmin SSO and OAuth endpoints, as used by the Garmin Connect mobile app
+const (
+	ssoURL           = "https://sso.garmin.com/sso"
+	ssoEmbedURL      = ssoURL + "/embed"
+	ssoSigninURL     = ssoURL + "/signin"
+	ssoMFAURL        = ssoURL + "/verifyMFA/loginEnterMfaCode"
+	connectAPIURL    = "https://connectapi.garmin.com"
+	preauthorizedURL = connectAPIURL + "/oauth-service/oauth/preauthorized"
+	exchangeURL      = connectAPIURL + "/oauth-service/oauth/exchange/user/2.0"
+
+	ssoUserAgent    = "GCM-iOS-5.7.2.1"
+	mobileUserAgent = "com.garmin.android.apps.connectmobile"
+)
+
+var (
+	csrfPattern   = regexp.MustCompile(`name="_csrf"\s+value="(.+?)"`)
+	titlePattern  = regexp.MustCompile(`<title>(.+?)</title>`)
+	ticketPattern = regexp.MustCompile(`embed\?ticket=([^"]+)"`)
+)
+
+// OAuth1Token is the long-lived token obtained from an SSO ticket. It is
+// exchanged for short-lived OAuth2 tokens.
+type OAuth1Token struct {
+	Token       string `json:"oauth_token"`
+	TokenSecret string `json:"oauth_token_secret"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// OAuth2Token is the bearer token of the Connect API. Expiry times are Unix
+// seconds, as garth stores them.
+type OAuth2Token struct {
+	Scope                 string `json:"scope"`
+	JTI                   string `json:"jti"`
+	AccessToken           string `json:"access_token"`
+	RefreshToken          string `json:"refresh_token"`
+	TokenType             string `json:"token_type"`
+	ExpiresIn             int64  `json:"expires_in"`
+	ExpiresAt             int64  `json:"expires_at"`
+	RefreshTokenExpiresIn int64  `json:"refresh_token_expires_in"`
+	RefreshTokenExpiresAt int64  `json:"refresh_token_expires_at"`
+}
+
+// expired reports whether the token is expired or about to be
+func (t *OAuth2Token) expired() bool {
+	return t == nil || time.Now().Add(time.Minute).After(time.Unix(t.ExpiresAt, 0))
+}
+
+// consumer identifies the application to the OAuth endpoints
+type consumer struct {
+	Key    string
+	Secret string
+}
+
+// mobileConsumer is the OAuth1 consumer of the Garmin Connect mobile app, as
+// published by garth. NativeClient.SetConsumer replaces it if Garmin changes it.
+var mobileConsumer = consumer{
+	Key:    "fc3e99d2-118c-44b8-8ae3-03370dde24c0",
+	Secret: "E08WAR897WEy2knn7aFBrvegVAf0AFdWBBF",
+}
+
+// ssoLogin signs in with the username and password and returns the SSO
+// ticket. The client must have a cookie jar. Accounts with multi-factor
+// authentication need promptMFA; without it they fail with ErrReauthRequired.
+func ssoLogin(client *http.Client, username, password string, promptMFA MFAPrompt) (string, error) {
+	embedParams := url.Values{
+		"id":          {"gauth-widget"},
+		"embedWidget": {"true"},
+		"gauthHost":   {ssoURL},
+	}
+	signinParams := url.Values{
+		"id":                              {"gauth-widget"},
+		"embedWidget":                     {"true"},
+		"gauthHost":                       {ssoEmbedURL},
+		"service":                         {ssoEmbedURL},
+		"source":                          {ssoEmbedURL},
+		"redirectAfterAccountLoginUrl":    {ssoEmbedURL},
+		"redirectAfterAccountCreationUrl": {ssoEmbedURL},
+	}
+
+	// The embed page sets the session cookies, the sign-in page the CSRF token
+	if _, err := ssoRequest(client, "GET", ssoEmbedURL+"?"+embedParams.Encode(), "", nil); err != nil {
+		return "", err
+	}
+	signinURL := ssoSigninURL + "?" + signinParams.Encode()
+	page, err := ssoRequest(client, "GET", signinURL, ssoEmbedURL+"?"+embedParams.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	csrf := csrfPattern.FindStringSubmatch(page)
+	if csrf == nil {
+		return "", fmt.Errorf("Garmin SSO: no CSRF token in the sign-in page")
+	}
+
+	form := url.Values{
+		"username": {username},
+		"password": {password},
+		"embed":    {"true"},
+		"_csrf":    {csrf[1]},
+	}
+	page, err = ssoRequest(client, "POST", signinURL, signinURL, form)
+	if err != nil {
+		return "", err
+	}
+
+	title := pageTitle(page)
+	if strings.Contains(title, "MFA") {
+		if promptMFA == nil {
+			return "", &AuthError{Reason: "the account requires multi-factor authentication", ReauthRequired: true}
+		}
+		if page, err = submitMFACode(client, page, signinParams, promptMFA); err != nil {
+			return "", err
+		}
+		title = pageTitle(page)
+	}
+	if title != "Success" {
+		return "", &AuthError{Reason: fmt.Sprintf("unexpected sign-in page %q (check credentials)", title)}
+	}
+
+	ticket := ticketPattern.FindStringSubmatch(page)
+	if ticket == nil {
+		return "", fmt.Errorf("Garmin SSO: no ticket in the sign-in response")
+	}
+	return ticket[1], nil
+}
+
+// submitMFACode asks for the MFA code and sends it from the MFA page
+func submitMFACode(client *http.Client, page string, signinParams url.Values, promptMFA MFAPrompt) (string, error) {
+	csrf := csrfPattern.FindStringSubmatch(page)
+	if csrf == nil {
+		return "", fmt.Errorf("Garmin SSO: no CSRF token in the MFA page")
+	}
+	code, err := promptMFA()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the MFA code: %w", err)
+	}
+
+	form := url.Values{
+		"mfa-verification-code": {strings.TrimSpace(code)},
+		"embed":                 {"true"},
+		"_csrf":                 {csrf[1]},
+		"fromPage":              {"setupEnterMfaCode"},
+	}
+	mfaURL := ssoMFAURL + "?" + signinParams.Encode()
+	return ssoRequest(client, "POST", mfaURL, ssoSigninURL+"?"+signinParams.Encode(), form)
+}
+
+// pageTitle returns the title of an SSO page
+func pageTitle(page string) string {
+	if match := titlePattern.FindStringSubmatch(page); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// ssoRequest sends a request to the SSO pages and returns the body
+func ssoRequest(client *http.Client, method, target, referer string, form url.Values) (string, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", ssoUserAgent)
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Garmin SSO request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &HTTPError{Op: "sign-in", StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	return string(data), nil
+}
+
+// preauthorize turns an SSO ticket into an OAuth1 token
+func preauthorize(ctx context.Context, config *oauth1.Config, ticket string) (*OAuth1Token, error) {
+	query := url.Values{
+		"ticket":             {ticket},
+		"login-url":          {ssoEmbedURL},
+		"accepts-mfa-tokens": {"true"},
+	}
+	req, err := http.NewRequest("GET", preauthorizedURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", mobileUserAgent)
+
+	data, err := doOAuth1(config.Client(ctx, oauth1.NewToken("", "")), req, "OAuth1 token")
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OAuth1 token: %w", err)
+	}
+	token := &OAuth1Token{
+		Token:       values.Get("oauth_token"),
+		TokenSecret: values.Get("oauth_token_secret"),
+		MFAToken:    values.Get("mfa_token"),
+	}
+	if token.Token == "" || token.TokenSecret == "" {
+		return nil, fmt.Errorf("Garmin returned an empty OAuth1 token")
+	}
+	return token, nil
+}
+
+// exchange trades the OAuth1 token for a fresh OAuth2 token
+func exchange(ctx context.Context, config *oauth1.Config, token *OAuth1Token) (*OAuth2Token, error) {
+	form := url.Values{}
+	if token.MFAToken != "" {
+		form.Set("mfa_token", token.MFAToken)
+	}
+	req, err := http.NewRequest("POST", exchangeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", mobileUserAgent)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	data, err := doOAuth1(config.Client(ctx, oauth1.NewToken(token.Token, token.TokenSecret)), req, "OAuth2 exchange")
+	if err != nil {
+		return nil, err
+	}
+
+	var oauth2 OAuth2Token
+	if err := json.Unmarshal(data, &oauth2); err != nil {
+		return nil, fmt.Errorf("failed to parse OAuth2 token: %w", err)
+	}
+	if oauth2.AccessToken == "" {
+		return nil, fmt.Errorf("Garmin returned an empty OAuth2 token")
+	}
+	now := time.Now().Unix()
+	oauth2.ExpiresAt = now + oauth2.ExpiresIn
+	oauth2.RefreshTokenExpiresAt = now + oauth2.RefreshTokenExpiresIn
+	return &oauth2, nil
+}
+
+// doOAuth1 sends a request signed by an OAuth1 client and returns the body
+func doOAuth1(client *http.Client, req *http.Request, op string) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{Op: op, StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	return data, nil
+}
+
+// newSSOClient returns an HTTP client with a fresh cookie jar
+func newSSOClient() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Jar: jar, Timeout: 60 * time.Second}
+}
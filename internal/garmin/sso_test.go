@@ -0,0 +1,215 @@
+package garmin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/oauth1"
+)
+
+// redirectTransport sends every request to a test server, whatever its host
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// testClient returns a client with a cookie jar that talks to server
+func testClient(t *testing.T, server *httptest.Server) *http.Client {
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Jar: jar, Transport: redirectTransport{target: target}}
+}
+
+// fakeSSO serves the SSO pages. The sign-in answers the page titled title,
+// and the MFA page accepts the code 123456.
+func fakeSSO(t *testing.T, title string) *httptest.Server {
+	const csrf = "csrf-token-1"
+	const success = `<html><head><title>Success</title></head><body>
+<script>var response_url = "https:\/\/sso.garmin.com\/sso\/embed?ticket=ST-0123-abcdef-cas";</script></body></html>`
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sso/embed", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "SESSION", Value: "s1", Path: "/"})
+	})
+	mux.HandleFunc("GET /sso/signin", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>GARMIN Authentication Application</title></head>
+<form><input type="hidden" name="_csrf"  value="%s" /></form></html>`, csrf)
+	})
+	mux.HandleFunc("POST /sso/signin", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("SESSION"); err != nil {
+			t.Error("sign-in posted without the embed page cookie")
+		}
+		if r.PostFormValue("_csrf") != csrf {
+			t.Errorf("sign-in posted CSRF token %q", r.PostFormValue("_csrf"))
+		}
+		if r.PostFormValue("username") != "athlete@example.com" || r.PostFormValue("password") != "secret" {
+			t.Errorf("sign-in posted credentials %q/%q", r.PostFormValue("username"), r.PostFormValue("password"))
+		}
+		if title == "Success" {
+			fmt.Fprint(w, success)
+			return
+		}
+		fmt.Fprintf(w, `<html><head><title>%s</title></head>
+<form><input type="hidden" name="_csrf" value="%s" /></form></html>`, title, csrf)
+	})
+	mux.HandleFunc("POST /sso/verifyMFA/loginEnterMfaCode", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("_csrf") != csrf {
+			t.Errorf("MFA code posted CSRF token %q", r.PostFormValue("_csrf"))
+		}
+		if r.PostFormValue("mfa-verification-code") != "123456" {
+			fmt.Fprint(w, `<html><head><title>Enter MFA code for login</title></head></html>`)
+			return
+		}
+		fmt.Fprint(w, success)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSSOLogin(t *testing.T) {
+	server := fakeSSO(t, "Success")
+	ticket, err := ssoLogin(testClient(t, server), "athlete@example.com", "secret", nil)
+	if err != nil {
+		t.Fatalf("ssoLogin: %v", err)
+	}
+	if ticket != "ST-0123-abcdef-cas" {
+		t.Errorf("ticket = %q, want ST-0123-abcdef-cas", ticket)
+	}
+}
+
+func TestSSOLoginRejected(t *testing.T) {
+	server := fakeSSO(t, "GARMIN Authentication Application")
+	_, err := ssoLogin(testClient(t, server), "athlete@example.com", "secret", nil)
+
+	var authErr *AuthError
+	if !errors.As(err, &authErr) || authErr.ReauthRequired {
+		t.Fatalf("ssoLogin error = %v, want a plain AuthError", err)
+	}
+	if !strings.Contains(err.Error(), "GARMIN Authentication Application") {
+		t.Errorf("error does not name the page: %v", err)
+	}
+}
+
+func TestSSOLoginMFA(t *testing.T) {
+	server := fakeSSO(t, "Enter MFA code for login")
+
+	// Without a prompt, only garmin-login can help
+	_, err := ssoLogin(testClient(t, server), "athlete@example.com", "secret", nil)
+	if !errors.Is(err, ErrReauthRequired) {
+		t.Fatalf("ssoLogin without prompt: error = %v, want ErrReauthRequired", err)
+	}
+
+	prompted := 0
+	prompt := func() (string, error) {
+		prompted++
+		return " 123456\n", nil
+	}
+	ticket, err := ssoLogin(testClient(t, server), "athlete@example.com", "secret", prompt)
+	if err != nil {
+		t.Fatalf("ssoLogin with prompt: %v", err)
+	}
+	if prompted != 1 {
+		t.Errorf("prompted %d times, want 1", prompted)
+	}
+	if ticket != "ST-0123-abcdef-cas" {
+		t.Errorf("ticket = %q, want ST-0123-abcdef-cas", ticket)
+	}
+
+	wrong := func() (string, error) { return "000000", nil }
+	if _, err := ssoLogin(testClient(t, server), "athlete@example.com", "secret", wrong); err == nil {
+		t.Error("ssoLogin accepted a wrong MFA code")
+	}
+}
+
+func TestPreauthorizeAndExchange(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth-service/oauth/preauthorized", func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "OAuth ") || !strings.Contains(auth, `oauth_consumer_key="consumer-key"`) {
+			t.Errorf("preauthorize Authorization = %q", auth)
+		}
+		if r.URL.Query().Get("ticket") != "ST-0123" {
+			t.Errorf("preauthorize ticket = %q", r.URL.Query().Get("ticket"))
+		}
+		fmt.Fprint(w, "oauth_token=token1&oauth_token_secret=secret1&mfa_token=mfa1")
+	})
+	mux.HandleFunc("POST /oauth-service/oauth/exchange/user/2.0", func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, `oauth_token="token1"`) {
+			t.Errorf("exchange Authorization = %q", auth)
+		}
+		if r.PostFormValue("mfa_token") != "mfa1" {
+			t.Errorf("exchange mfa_token = %q", r.PostFormValue("mfa_token"))
+		}
+		fmt.Fprint(w, `{"access_token": "access1", "refresh_token": "refresh1", "token_type": "Bearer",
+			"expires_in": 3600, "refresh_token_expires_in": 7200}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := oauth1.NewConfig("consumer-key", "consumer-secret")
+	ctx := context.WithValue(context.Background(), oauth1.HTTPClient, testClient(t, server))
+
+	token1, err := preauthorize(ctx, config, "ST-0123")
+	if err != nil {
+		t.Fatalf("preauthorize: %v", err)
+	}
+	want := OAuth1Token{Token: "token1", TokenSecret: "secret1", MFAToken: "mfa1"}
+	if *token1 != want {
+		t.Errorf("OAuth1 token = %+v, want %+v", *token1, want)
+	}
+
+	before := time.Now().Unix()
+	token2, err := exchange(ctx, config, token1)
+	after := time.Now().Unix()
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if token2.AccessToken != "access1" {
+		t.Errorf("access token = %q, want access1", token2.AccessToken)
+	}
+	if token2.ExpiresAt < before+3600 || token2.ExpiresAt > after+3600 {
+		t.Errorf("ExpiresAt = %d, want now + 3600 (%d)", token2.ExpiresAt, before+3600)
+	}
+	if token2.RefreshTokenExpiresAt < before+7200 || token2.RefreshTokenExpiresAt > after+7200 {
+		t.Errorf("RefreshTokenExpiresAt = %d, want now + 7200 (%d)", token2.RefreshTokenExpiresAt, before+7200)
+	}
+	if token2.expired() {
+		t.Error("a fresh token is expired")
+	}
+}
+
+func TestOAuth2TokenExpired(t *testing.T) {
+	now := time.Now().Unix()
+	for _, test := range []struct {
+		token *OAuth2Token
+		want  bool
+	}{
+		{nil, true},
+		{&OAuth2Token{ExpiresAt: now - 10}, true},
+		{&OAuth2Token{ExpiresAt: now + 30}, true}, // within the margin
+		{&OAuth2Token{ExpiresAt: now + 3600}, false},
+	} {
+		if got := test.token.expired(); got != test.want {
+			t.Errorf("expired() with %+v = %v, want %v", test.token, got, test.want)
+		}
+	}
+}
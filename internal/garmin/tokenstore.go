@@ -0,0 +1,126 @@
+package garmin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Token file names, the ones garth (the library under garminconnect) dumps,
+// so the Python and native clients share a token store
+const (
+	oauth1TokenFile = "oauth1_token.json"
+	oauth2TokenFile = "oauth2_token.json"
+)
+
+// TokenStore keeps Garmin session tokens between runs, so a run reuses the
+// session of the previous one instead of logging in with the password. The
+// directory is only accessible by its owner.
+type TokenStore struct {
+	dir string
+}
+
+// NewTokenStore opens the token store in dir, creating it if needed
+func NewTokenStore(dir string) (*TokenStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create token directory: %w", err)
+	}
+	// MkdirAll keeps the permissions of an existing directory
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to restrict token directory: %w", err)
+	}
+	return &TokenStore{dir: dir}, nil
+}
+
+// Dir returns the directory of the store
+func (s *TokenStore) Dir() string {
+	return s.dir
+}
+
+// Load returns the stored tokens. It returns an error wrapping
+// os.ErrNotExist when no session is stored; the OAuth2 token is nil if only
+// the OAuth1 token is.
+func (s *TokenStore) Load() (*OAuth1Token, *OAuth2Token, error) {
+	var oauth1 OAuth1Token
+	if err := s.read(oauth1TokenFile, &oauth1); err != nil {
+		return nil, nil, err
+	}
+	if oauth1.Token == "" || oauth1.TokenSecret == "" {
+		return nil, nil, fmt.Errorf("%s is incomplete", oauth1TokenFile)
+	}
+
+	var oauth2 OAuth2Token
+	if err := s.read(oauth2TokenFile, &oauth2); errors.Is(err, os.ErrNotExist) {
+		return &oauth1, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return &oauth1, &oauth2, nil
+}
+
+// Save stores the tokens, replacing the previous ones
+func (s *TokenStore) Save(oauth1 *OAuth1Token, oauth2 *OAuth2Token) error {
+	if err := s.write(oauth1TokenFile, oauth1); err != nil {
+		return err
+	}
+	if oauth2 == nil {
+		return s.remove(oauth2TokenFile)
+	}
+	return s.write(oauth2TokenFile, oauth2)
+}
+
+// Clear removes the stored tokens
+func (s *TokenStore) Clear() error {
+	if err := s.remove(oauth1TokenFile); err != nil {
+		return err
+	}
+	return s.remove(oauth2TokenFile)
+}
+
+func (s *TokenStore) read(name string, value any) error {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// write replaces a token file atomically, so a crash never leaves half a
+// token behind
+func (s *TokenStore) write(name string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// CreateTemp already creates the file with mode 0600
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *TokenStore) remove(name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", name, err)
+	}
+	return nil
+}
@@ -1,15 +1,71 @@
 package garmin
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // Activity represents a Garmin activity
 type Activity struct {
-	ActivityID   int64     `json:"activityId"`
-	ActivityName string    `json:"activityName"`
-	ActivityType string    `json:"activityType"`
-	StartTime    time.Time `json:"startTimeLocal"`
-	Distance     float64   `json:"distance"`     // meters
-	Duration     float64   `json:"duration"`     // seconds
-	AvgSpeed     float64   `json:"averageSpeed"` // m/s
-	Calories     float64   `json:"calories"`
+	ActivityID   int64  `json:"activityId"`
+	ActivityName string `json:"activityName"`
+	ActivityType string `json:"activityType"`
+	// WorkoutName is the name of the structured workout the activity was
+	// recorded from, empty if there is none
+	WorkoutName string `json:"workoutName"`
+	// StartTimeGMT is the start instant in UTC
+	StartTimeGMT time.Time `json:"startTimeGMT"`
+	// StartTimeLocal is the same instant in the time zone the activity was
+	// recorded in
+	StartTimeLocal time.Time `json:"startTimeLocal"`
+	// StartTime is the same instant in the athlete's time zone, which decides
+	// the activity date. See In.
+	StartTime time.Time `json:"-"`
+	Distance  float64   `json:"distance"`     // meters
+	Duration  float64   `json:"duration"`     // seconds
+	AvgSpeed  float64   `json:"averageSpeed"` // m/s
+	Calories  float64   `json:"calories"`
+}
+
+// In returns the activity with StartTime set in the given time zone, or in
+// the time zone it was recorded in if loc is nil
+func (a Activity) In(loc *time.Location) Activity {
+	start := a.StartTimeGMT
+	if start.IsZero() {
+		start = a.StartTimeLocal
+	}
+	if loc != nil {
+		a.StartTime = start.In(loc)
+	} else if !a.StartTimeLocal.IsZero() {
+		a.StartTime = a.StartTimeLocal
+	} else {
+		a.StartTime = start
+	}
+	return a
+}
+
+// indoorTypes are the Garmin type keys of activities recorded indoors
+var indoorTypes = map[string]bool{
+	"indoor_cycling":    true,
+	"virtual_ride":      true,
+	"treadmill_running": true,
+	"indoor_running":    true,
+	"virtual_run":       true,
+	"lap_swimming":      true,
+	"indoor_rowing":     true,
+	"indoor_walking":    true,
+	"indoor_cardio":     true,
+	"strength_training": true,
+	"elliptical":        true,
+	"stair_climbing":    true,
+}
+
+// Sport returns the sport the activity belongs to
+func (a Activity) Sport() Sport {
+	return SportForType(a.ActivityType)
+}
+
+// IsIndoor reports whether the activity type is an indoor one
+func (a Activity) IsIndoor() bool {
+	return indoorTypes[strings.ToLower(a.ActivityType)]
 }
@@ -0,0 +1,109 @@
+package ido
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"garmin-to-ido/internal/destination"
+)
+
+// activitiesURL is the endpoint the athlete calendar loads its activities
+// from. iDO does not document it: the request and the fields below are the
+// ones the calendar uses, and a response that does not match them fails the
+// listing, which the sync then reports as a warning.
+const activitiesURL = idoBaseURL + "/v-get-activities"
+
+// listTimeout bounds the activity list request, which runs before every sync
+// while the daemon holds its run lock
+const listTimeout = time.Minute
+
+// idoActivity is an activity as listed by iDO. Start times are wall clock
+// times without a zone, in the athlete's time zone.
+type idoActivity struct {
+	ID        json.Number `json:"id"`
+	Name      string      `json:"actName"`
+	StartTime string      `json:"startTime"` // "2006-01-02 15:04:05"
+	Duration  float64     `json:"duration"`  // seconds
+}
+
+var _ destination.Lister = (*Client)(nil)
+
+// ListActivities returns the athlete's iDO activities started between two
+// dates (inclusive), using the browser session obtained in Login
+func (c *Client) ListActivities(from, to time.Time) ([]destination.RemoteActivity, error) {
+	cookieStr, err := c.sessionCookies()
+	if err != nil {
+		return nil, err
+	}
+	return c.listActivities(cookieStr, from, to)
+}
+
+// listActivities requests and parses the activity list with the given
+// session cookies
+func (c *Client) listActivities(cookieStr string, from, to time.Time) ([]destination.RemoteActivity, error) {
+	query := url.Values{}
+	query.Set("start", from.Format("2006-01-02"))
+	query.Set("end", to.Format("2006-01-02"))
+
+	req, err := http.NewRequest("GET", activitiesURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Cookie", cookieStr)
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("Referer", athleteURL)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/141.0.0.0 Safari/537.36")
+	req.Header.Set("Sec-Fetch-Dest", "empty")
+	req.Header.Set("Sec-Fetch-Mode", "cors")
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+
+	resp, err := c.listClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list activities: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// An expired session is redirected to the login page
+	if strings.HasPrefix(resp.Request.URL.String(), loginURL) {
+		return nil, fmt.Errorf("activity list redirected to login: %w", ErrSessionExpired)
+	}
+	if resp.StatusCode != 200 {
+		return nil, &HTTPError{Step: StepListActivities, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var listed []idoActivity
+	if err := json.Unmarshal(body, &listed); err != nil {
+		return nil, fmt.Errorf("failed to parse activity list: %w", err)
+	}
+
+	activities := make([]destination.RemoteActivity, 0, len(listed))
+	for _, activity := range listed {
+		if activity.ID.String() == "" {
+			return nil, fmt.Errorf("unexpected activity list format: activity without id")
+		}
+		startTime, err := time.ParseInLocation("2006-01-02 15:04:05", activity.StartTime, c.location)
+		if err != nil {
+			// Planned workouts have no start time yet
+			continue
+		}
+		activities = append(activities, destination.RemoteActivity{
+			ID:        activity.ID.String(),
+			Name:      activity.Name,
+			StartTime: startTime,
+			Duration:  activity.Duration,
+		})
+	}
+
+	return activities, nil
+}
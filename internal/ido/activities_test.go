@@ -0,0 +1,167 @@
+package ido
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"garmin-to-ido/internal/destination"
+)
+
+// redirectTransport sends every request to a test server, whatever its host.
+// Responses keep the original request, whose URL the client checks.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := req.Clone(req.Context())
+	sent.URL.Scheme = t.target.Scheme
+	sent.URL.Host = t.target.Host
+	resp, err := http.DefaultTransport.RoundTrip(sent)
+	if resp != nil {
+		resp.Request = req
+	}
+	return resp, err
+}
+
+// testClient returns an iDO client whose activity list requests go to handler
+func testClient(t *testing.T, loc *time.Location, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{
+		location:   loc,
+		listClient: &http.Client{Timeout: 5 * time.Second, Transport: redirectTransport{target: target}},
+	}
+}
+
+// activityList is a response of the calendar endpoint: two activities, one
+// with a string id, and a planned workout without start time
+const activityList = `[
+	{"id": 101, "actName": "Morning Ride", "startTime": "2024-05-01 07:30:00", "duration": 3600, "sport": "bike"},
+	{"id": "102", "actName": "Evening Run", "startTime": "2024-05-01 18:00:05", "duration": 1800.5},
+	{"id": 103, "actName": "Planned intervals", "startTime": null, "duration": 0}
+]`
+
+func TestListActivities(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+
+	client := testClient(t, paris, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v-get-activities" {
+			t.Errorf("requested %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("start") != "2024-05-01" || r.URL.Query().Get("end") != "2024-05-02" {
+			t.Errorf("requested range %s", r.URL.RawQuery)
+		}
+		if r.Header.Get("Cookie") != "PHPSESSID=abc" {
+			t.Errorf("Cookie = %q", r.Header.Get("Cookie"))
+		}
+		fmt.Fprint(w, activityList)
+	})
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, paris)
+	activities, err := client.listActivities("PHPSESSID=abc", from, from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("listActivities: %v", err)
+	}
+
+	want := []destination.RemoteActivity{
+		{ID: "101", Name: "Morning Ride", StartTime: time.Date(2024, 5, 1, 7, 30, 0, 0, paris), Duration: 3600},
+		{ID: "102", Name: "Evening Run", StartTime: time.Date(2024, 5, 1, 18, 0, 5, 0, paris), Duration: 1800.5},
+	}
+	if len(activities) != len(want) {
+		t.Fatalf("listed %d activities, want %d: %+v", len(activities), len(want), activities)
+	}
+	for i := range want {
+		got := activities[i]
+		if got.ID != want[i].ID || got.Name != want[i].Name || !got.StartTime.Equal(want[i].StartTime) || got.Duration != want[i].Duration {
+			t.Errorf("activity %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	// The Garmin start time is in UTC, iDO shows it in the athlete's zone
+	ride := time.Date(2024, 5, 1, 5, 30, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name      string
+		startTime time.Time
+		duration  float64
+		wantID    string
+	}{
+		{"same start", ride, 3600, "101"},
+		{"start rounded", ride.Add(90 * time.Second), 3600, "101"},
+		{"start too far", ride.Add(3 * time.Minute), 3600, ""},
+		{"duration recomputed", ride, 3650, "101"},
+		{"other duration", ride, 5400, ""},
+		{"unknown duration", ride.Add(-time.Minute), 0, "101"},
+	} {
+		match, ok := destination.FindMatch(activities, destination.Upload{StartTime: test.startTime, Duration: test.duration})
+		if ok != (test.wantID != "") || match.ID != test.wantID {
+			t.Errorf("%s: FindMatch = %q, %v, want %q", test.name, match.ID, ok, test.wantID)
+		}
+	}
+}
+
+func TestListActivitiesErrors(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name    string
+		handler http.HandlerFunc
+		check   func(error) bool
+	}{
+		{"session expired", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/login" {
+				fmt.Fprint(w, "<html>login</html>")
+				return
+			}
+			http.Redirect(w, r, "/login", http.StatusFound)
+		}, func(err error) bool { return errors.Is(err, ErrSessionExpired) }},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "oops", http.StatusInternalServerError)
+		}, func(err error) bool {
+			var httpErr *HTTPError
+			return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusInternalServerError
+		}},
+		{"not a list", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"activities": []}`)
+		}, func(err error) bool { return err != nil }},
+		{"activity without id", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"actName": "Ride", "startTime": "2024-05-01 07:30:00"}]`)
+		}, func(err error) bool { return err != nil }},
+	} {
+		client := testClient(t, time.UTC, test.handler)
+		_, err := client.listActivities("PHPSESSID=abc", from, from)
+		if !test.check(err) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
+
+func TestListActivitiesTimeout(t *testing.T) {
+	release := make(chan struct{})
+	client := testClient(t, time.UTC, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer close(release)
+	client.listClient.Timeout = 100 * time.Millisecond
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	start := time.Now()
+	if _, err := client.listActivities("PHPSESSID=abc", from, from); err == nil {
+		t.Fatal("listActivities did not fail on a stalled server")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("listActivities gave up after %s", elapsed)
+	}
+}
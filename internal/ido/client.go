@@ -4,12 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"garmin-to-ido/internal/destination"
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/metrics"
+
 	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/chromedp"
 )
@@ -21,16 +28,61 @@ const (
 	uploadURL   = idoBaseURL + "/athlete/activity/add"
 )
 
+// ErrSessionExpired is returned when iDO redirects the browser to the login page
+var ErrSessionExpired = errors.New("iDO session expired")
+
 // Client is an iDO Sport client using browser automation
 type Client struct {
 	username string
 	password string
 	ctx      context.Context
 	cancel   context.CancelFunc
+	// location is the athlete's time zone, in which iDO shows start times
+	location *time.Location
+
+	// listClient sends the activity list requests
+	listClient *http.Client
+
+	// metrics records the upload step times. Nil disables them.
+	metrics *metrics.Recorder
+	// loggedInAt is the time of the last successful login, in Unix nanoseconds
+	loggedInAt atomic.Int64
 }
 
 // NewClient creates a new iDO Sport client
 func NewClient(username, password string) (*Client, error) {
+	c := &Client{
+		username:   username,
+		password:   password,
+		location:   time.Local,
+		listClient: &http.Client{Timeout: listTimeout},
+	}
+	c.startBrowser()
+
+	return c, nil
+}
+
+// SetMetrics makes the client record the time of each upload step
+func (c *Client) SetMetrics(recorder *metrics.Recorder) {
+	c.metrics = recorder
+}
+
+// LoggedInAt returns the time of the last successful login, zero before
+func (c *Client) LoggedInAt() time.Time {
+	if nanos := c.loggedInAt.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// SetLocation sets the athlete's time zone, in which iDO start times are
+// read. Defaults to the local time zone.
+func (c *Client) SetLocation(loc *time.Location) {
+	c.location = loc
+}
+
+// startBrowser creates a fresh headless Chrome context for the client
+func (c *Client) startBrowser() {
 	// Create chrome context
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
 		chromedp.Flag("headless", true),
@@ -41,12 +93,8 @@ func NewClient(username, password string) (*Client, error) {
 	allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
 	ctx, _ := chromedp.NewContext(allocCtx)
 
-	return &Client{
-		username: username,
-		password: password,
-		ctx:      ctx,
-		cancel:   cancel,
-	}, nil
+	c.ctx = ctx
+	c.cancel = cancel
 }
 
 // Login logs into iDO Sport
@@ -93,83 +141,115 @@ func (c *Client) Login() error {
 		}
 	}
 
+	c.loggedInAt.Store(time.Now().UnixNano())
 	return nil
 }
 
-// mapActivityType maps Garmin activity types to iDO sport types
-func mapActivityType(garminType string) string {
-	// Map common Garmin activity types to iDO sport types
-	typeMap := map[string]string{
-		"cycling":         "bike",
-		"road_biking":     "bike",
-		"mountain_biking": "bike",
-		"gravel_cycling":  "bike",
-		"indoor_cycling":  "bike",
-		"virtual_ride":    "bike",
-		"running":         "run",
-		"trail_running":   "run",
-		"treadmill":       "run",
-		"walking":         "walk",
-		"hiking":          "walk",
-		"swimming":        "swim",
-	}
-
-	// Convert to lowercase for case-insensitive matching
-	garminTypeLower := garminType
-	if mapped, ok := typeMap[garminTypeLower]; ok {
-		return mapped
-	}
-
-	// Default to bike if unknown
-	return "bike"
+// CheckSession verifies that the browser session is still logged into iDO
+func (c *Client) CheckSession() error {
+	var pageURL string
+
+	err := chromedp.Run(c.ctx,
+		chromedp.Navigate(athleteURL),
+		chromedp.Sleep(2*time.Second),
+		chromedp.Location(&pageURL),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reach athlete page: %w", err)
+	}
+
+	if strings.HasPrefix(pageURL, loginURL) {
+		return ErrSessionExpired
+	}
+
+	return nil
+}
+
+// EnsureSession checks the browser session and logs in again if it has
+// expired. If the browser itself is gone, a new one is started first.
+func (c *Client) EnsureSession() error {
+	err := c.CheckSession()
+	if err == nil {
+		return nil
+	}
+
+	// Anything but an expired session means the browser itself is unusable
+	// (e.g. Chrome crashed), so start a new one
+	if !errors.Is(err, ErrSessionExpired) {
+		c.Close()
+		c.startBrowser()
+	}
+
+	if err := c.Login(); err != nil {
+		return fmt.Errorf("failed to restore iDO session: %w", err)
+	}
+
+	return nil
+}
+
+// idoSportTypes maps sports to the iDO sport types known to be accepted
+var idoSportTypes = map[garmin.Sport]string{
+	garmin.SportBike: "bike",
+	garmin.SportRun:  "run",
+	garmin.SportSwim: "swim",
+	garmin.SportWalk: "walk",
+}
+
+// idoDefaultSportType is sent for sports iDO has no known type for
+// (strength sessions, other activities)
+const idoDefaultSportType = "bike"
+
+// MapActivityType maps Garmin activity types to iDO sport types
+func MapActivityType(garminType string) string {
+	if sportType, ok := idoSportTypes[garmin.SportForType(garminType)]; ok {
+		return sportType
+	}
+	return idoDefaultSportType
 }
 
 // logRequest logs HTTP request details
-func logRequest(req *http.Request, body []byte, debug bool) {
+func logRequest(out io.Writer, req *http.Request, body []byte, debug bool) {
 	if debug {
-		fmt.Printf("\n=== REQUEST ===\n")
-		fmt.Printf("%s %s\n", req.Method, req.URL.String())
-		fmt.Printf("Headers:\n")
+		fmt.Fprintf(out, "\n=== REQUEST ===\n")
+		fmt.Fprintf(out, "%s %s\n", req.Method, req.URL.String())
+		fmt.Fprintf(out, "Headers:\n")
 		for key, values := range req.Header {
 			for _, value := range values {
-				fmt.Printf("  %s: %s\n", key, value)
+				fmt.Fprintf(out, "  %s: %s\n", key, value)
 			}
 		}
 		if len(body) > 0 && len(body) < 1000 {
-			fmt.Printf("Body: %s\n", string(body))
+			fmt.Fprintf(out, "Body: %s\n", string(body))
 		} else if len(body) > 0 {
-			fmt.Printf("Body: [%d bytes]\n", len(body))
+			fmt.Fprintf(out, "Body: [%d bytes]\n", len(body))
 		}
-		fmt.Printf("===============\n\n")
+		fmt.Fprintf(out, "===============\n\n")
 	}
 }
 
 // logResponse logs HTTP response details
-func logResponse(resp *http.Response, body []byte, debug bool) {
+func logResponse(out io.Writer, resp *http.Response, body []byte, debug bool) {
 	if debug {
-		fmt.Printf("\n=== RESPONSE ===\n")
-		fmt.Printf("Status: %d %s\n", resp.StatusCode, resp.Status)
-		fmt.Printf("Headers:\n")
+		fmt.Fprintf(out, "\n=== RESPONSE ===\n")
+		fmt.Fprintf(out, "Status: %d %s\n", resp.StatusCode, resp.Status)
+		fmt.Fprintf(out, "Headers:\n")
 		for key, values := range resp.Header {
 			for _, value := range values {
-				fmt.Printf("  %s: %s\n", key, value)
+				fmt.Fprintf(out, "  %s: %s\n", key, value)
 			}
 		}
 		if len(body) > 0 && len(body) < 10000 {
-			fmt.Printf("Body: %s\n", string(body))
+			fmt.Fprintf(out, "Body: %s\n", string(body))
 		} else if len(body) > 0 {
-			fmt.Printf("Body: [%d bytes]\n", len(body))
+			fmt.Fprintf(out, "Body: [%d bytes]\n", len(body))
 		}
-		fmt.Printf("================\n\n")
+		fmt.Fprintf(out, "================\n\n")
 	}
 }
 
-// UploadActivity uploads an activity to iDO Sport using the API
-func (c *Client) UploadActivity(activityData []byte, activityName, activityType string, activityDate time.Time, debug bool) error {
-	fmt.Printf("\n\n========================================\n")
-	fmt.Printf("Uploading activity: %s (%d bytes, type: %s, date: %s)\n", activityName, len(activityData), activityType, activityDate.Format("2006-01-02"))
-	fmt.Printf("========================================\n")
-
+// sessionCookies returns the browser's iDO cookies as a Cookie header value,
+// so API calls made with net/http share the logged-in session
+func (c *Client) sessionCookies() (string, error) {
 	// Get cookies from browser session for idosport.app domain
 	var cookies []*network.Cookie
 	if err := chromedp.Run(c.ctx, chromedp.ActionFunc(func(ctx context.Context) error {
@@ -187,7 +267,7 @@ func (c *Client) UploadActivity(activityData []byte, activityName, activityType
 		}
 		return nil
 	})); err != nil {
-		return fmt.Errorf("failed to get cookies: %w", err)
+		return "", fmt.Errorf("failed to get cookies: %w", err)
 	}
 
 	// Build cookie string and check for PHPSESSID
@@ -204,14 +284,29 @@ func (c *Client) UploadActivity(activityData []byte, activityName, activityType
 	}
 
 	if !hasSession {
-		return fmt.Errorf("no session cookie found - login may have failed")
+		return "", fmt.Errorf("no session cookie found - login may have failed: %w", ErrSessionExpired)
+	}
+
+	return cookieStr, nil
+}
+
+// UploadActivity uploads an activity to iDO Sport using the API and returns
+// the S3 key the FIT file was stored under. Progress is written to out.
+func (c *Client) UploadActivity(activityData []byte, activityName, activityType string, activityDate time.Time, out io.Writer, debug bool) (string, error) {
+	fmt.Fprintf(out, "\n\n========================================\n")
+	fmt.Fprintf(out, "Uploading activity: %s (%d bytes, type: %s, date: %s)\n", activityName, len(activityData), activityType, activityDate.Format("2006-01-02"))
+	fmt.Fprintf(out, "========================================\n")
+
+	cookieStr, err := c.sessionCookies()
+	if err != nil {
+		return "", err
 	}
 
 	// Step 1: Get S3 upload URL
-	fmt.Printf("\nStep 1: Get S3 upload URL\n")
+	fmt.Fprintf(out, "\nStep 1: Get S3 upload URL\n")
 	req, err := http.NewRequest("GET", idoBaseURL+"/v-get-s3-s-upurl", nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Cookie", cookieStr)
@@ -225,24 +320,26 @@ func (c *Client) UploadActivity(activityData []byte, activityName, activityType
 	req.Header.Set("Sec-Fetch-Mode", "cors")
 	req.Header.Set("Sec-Fetch-Site", "same-origin")
 
-	logRequest(req, nil, debug)
+	logRequest(out, req, nil, debug)
 
 	client := &http.Client{}
+	started := time.Now()
 	resp, err := client.Do(req)
+	c.metrics.UploadStep(metrics.StepGetUploadURL, time.Since(started))
 	if err != nil {
-		return fmt.Errorf("failed to get upload URL: %w", err)
+		return "", fmt.Errorf("failed to get upload URL: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return "", fmt.Errorf("failed to read response: %w", err)
 	}
 
-	logResponse(resp, body, debug)
+	logResponse(out, resp, body, debug)
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("failed to get upload URL: %d %s", resp.StatusCode, string(body))
+		return "", &HTTPError{Step: StepGetUploadURL, StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var s3Response struct {
@@ -251,14 +348,14 @@ func (c *Client) UploadActivity(activityData []byte, activityName, activityType
 	}
 
 	if err := json.Unmarshal(body, &s3Response); err != nil {
-		return fmt.Errorf("failed to parse S3 response: %w", err)
+		return "", fmt.Errorf("failed to parse S3 response: %w", err)
 	}
 
 	// Step 2: Upload file to S3
-	fmt.Printf("\nStep 2: Upload file to S3\n")
+	fmt.Fprintf(out, "\nStep 2: Upload file to S3\n")
 	s3Req, err := http.NewRequest("PUT", s3Response.URL, bytes.NewReader(activityData))
 	if err != nil {
-		return fmt.Errorf("failed to create S3 request: %w", err)
+		return "", fmt.Errorf("failed to create S3 request: %w", err)
 	}
 
 	s3Req.Header.Set("Content-Type", "application/fits")
@@ -271,28 +368,30 @@ func (c *Client) UploadActivity(activityData []byte, activityName, activityType
 	s3Req.Header.Set("Sec-Fetch-Mode", "cors")
 	s3Req.Header.Set("Sec-Fetch-Site", "cross-site")
 
-	logRequest(s3Req, activityData, debug)
+	logRequest(out, s3Req, activityData, debug)
 
+	started = time.Now()
 	s3Resp, err := client.Do(s3Req)
+	c.metrics.UploadStep(metrics.StepS3Upload, time.Since(started))
 	if err != nil {
-		return fmt.Errorf("failed to upload to S3: %w", err)
+		return "", fmt.Errorf("failed to upload to S3: %w", err)
 	}
 	defer s3Resp.Body.Close()
 
 	s3Body, _ := io.ReadAll(s3Resp.Body)
-	logResponse(s3Resp, s3Body,	debug)
+	logResponse(out, s3Resp, s3Body, debug)
 
 	if s3Resp.StatusCode != 200 {
-		return fmt.Errorf("S3 upload failed: %d %s", s3Resp.StatusCode, string(s3Body))
+		return "", &HTTPError{Step: StepS3Upload, StatusCode: s3Resp.StatusCode, Body: string(s3Body)}
 	}
 
 	// Step 3: Create activity record
-	fmt.Printf("\nStep 3: Create activity record\n")
+	fmt.Fprintf(out, "\nStep 3: Create activity record\n")
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
 
 	// Map the activity type to iDO sport type
-	idoSportType := mapActivityType(activityType)
+	idoSportType := MapActivityType(activityType)
 
 	writer.WriteField("actName", activityName)
 	writer.WriteField("dateString", activityDate.Format("2006-01-02"))
@@ -307,7 +406,7 @@ func (c *Client) UploadActivity(activityData []byte, activityName, activityType
 
 	activityReq, err := http.NewRequest("POST", idoBaseURL+"/v-add-activity-v2", bytes.NewReader(requestBody))
 	if err != nil {
-		return fmt.Errorf("failed to create activity request: %w", err)
+		return "", fmt.Errorf("failed to create activity request: %w", err)
 	}
 
 	activityReq.Header.Set("Cookie", cookieStr)
@@ -322,23 +421,25 @@ func (c *Client) UploadActivity(activityData []byte, activityName, activityType
 	activityReq.Header.Set("Sec-Fetch-Mode", "cors")
 	activityReq.Header.Set("Sec-Fetch-Site", "same-origin")
 
-	logRequest(activityReq, requestBody, debug)
+	logRequest(out, activityReq, requestBody, debug)
 
+	started = time.Now()
 	activityResp, err := client.Do(activityReq)
+	c.metrics.UploadStep(metrics.StepCreateActivity, time.Since(started))
 	if err != nil {
-		return fmt.Errorf("failed to create activity: %w", err)
+		return "", fmt.Errorf("failed to create activity: %w", err)
 	}
 	defer activityResp.Body.Close()
 
 	activityBody, err := io.ReadAll(activityResp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read activity creation response: %w", err)
+		return "", fmt.Errorf("failed to read activity creation response: %w", err)
 	}
 
-	logResponse(activityResp, activityBody, debug)
+	logResponse(out, activityResp, activityBody, debug)
 
 	if activityResp.StatusCode != 200 {
-		return fmt.Errorf("activity creation failed: %d %s", activityResp.StatusCode, string(activityBody))
+		return "", &HTTPError{Step: StepCreateActivity, StatusCode: activityResp.StatusCode, Body: string(activityBody)}
 	}
 
 	// Parse the response to check for success message
@@ -346,10 +447,33 @@ func (c *Client) UploadActivity(activityData []byte, activityName, activityType
 		Message string `json:"message"`
 	}
 	if err := json.Unmarshal(activityBody, &response); err == nil && response.Message != "" {
-		fmt.Printf("    Server response: %s\n", response.Message)
+		fmt.Fprintf(out, "    Server response: %s\n", response.Message)
 	}
 
-	return nil
+	return s3Response.Key, nil
+}
+
+var _ destination.Destination = (*Client)(nil)
+
+// Name identifies iDO Sport as a destination
+func (c *Client) Name() string {
+	return "ido"
+}
+
+// Upload implements destination.Destination using UploadActivity
+func (c *Client) Upload(upload destination.Upload) (string, error) {
+	return c.UploadActivity(upload.FitData, upload.ActivityName, upload.ActivityType, upload.StartTime, upload.Output, upload.Debug)
+}
+
+// Exists reports whether iDO already has an activity with the same start
+// time and duration as the upload
+func (c *Client) Exists(upload destination.Upload) (bool, error) {
+	remote, err := c.ListActivities(upload.StartTime, upload.StartTime)
+	if err != nil {
+		return false, err
+	}
+	_, ok := destination.FindMatch(remote, upload)
+	return ok, nil
 }
 
 // Close closes the browser and cleans up resources
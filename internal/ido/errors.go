@@ -0,0 +1,29 @@
+package ido
+
+import "fmt"
+
+// Request steps, used to tell which HTTP call failed
+const (
+	StepGetUploadURL   = "get upload URL"
+	StepS3Upload       = "S3 upload"
+	StepCreateActivity = "activity creation"
+	StepListActivities = "activity list"
+)
+
+// HTTPError is returned when iDO or S3 answers a request with an unexpected
+// status code
+type HTTPError struct {
+	Step       string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s failed: %d %s", e.Step, e.StatusCode, e.Body)
+}
+
+// Transient reports whether retrying the request may succeed: rate limiting,
+// server errors and any S3 PUT failure
+func (e *HTTPError) Transient() bool {
+	return e.Step == StepS3Upload || e.StatusCode == 429 || e.StatusCode >= 500
+}
@@ -0,0 +1,280 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status describes the upload state of an activity
+type Status string
+
+const (
+	StatusUploaded Status = "uploaded"
+	StatusFailed   Status = "failed"
+)
+
+// DefaultDestination is assumed for entries written before destinations
+// were recorded, when iDO was the only one
+const DefaultDestination = "ido"
+
+// Entry records what happened to a single Garmin activity for one
+// destination. Failed entries keep enough details about the activity to
+// replay the upload later from the archived FIT file.
+type Entry struct {
+	ActivityID   int64     `json:"activityId"`
+	Destination  string    `json:"destination"`
+	ActivityName string    `json:"activityName,omitempty"`
+	ActivityType string    `json:"activityType,omitempty"`
+	StartTime    time.Time `json:"startTime,omitempty"`
+	Status       Status    `json:"status"`
+	RemoteKey    string    `json:"remoteKey,omitempty"`
+	FitHash      string    `json:"fitHash,omitempty"`
+	FitPath      string    `json:"fitPath,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Attempts     int       `json:"attempts"`
+	FirstSeen    time.Time `json:"firstSeen"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	UploadedAt   time.Time `json:"uploadedAt,omitempty"`
+}
+
+// UnmarshalJSON also reads entries written before destinations were
+// introduced, which stored the remote key as s3Key
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	type entry Entry
+	var stored struct {
+		entry
+		S3Key string `json:"s3Key"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+
+	*e = Entry(stored.entry)
+	if e.RemoteKey == "" {
+		e.RemoteKey = stored.S3Key
+	}
+	return nil
+}
+
+// entryKey identifies an entry: one activity uploaded to one destination
+type entryKey struct {
+	destination string
+	activityID  int64
+}
+
+// Ledger is a persistent record of synced activities, stored as a JSON file
+type Ledger struct {
+	path    string
+	mu      sync.Mutex
+	entries map[entryKey]*Entry
+
+	// saveMu serializes writes to the ledger file
+	saveMu sync.Mutex
+}
+
+// Open loads the ledger stored at path. A missing file yields an empty ledger.
+func Open(path string) (*Ledger, error) {
+	l := &Ledger{
+		path:    path,
+		entries: make(map[entryKey]*Entry),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse ledger %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		if entry.Destination == "" {
+			entry.Destination = DefaultDestination
+		}
+		l.entries[entryKey{entry.Destination, entry.ActivityID}] = entry
+	}
+
+	return l, nil
+}
+
+// Get returns a copy of the entry for an activity and destination, if any
+func (l *Ledger) Get(destination string, activityID int64) (Entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[entryKey{destination, activityID}]
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// IsUploaded reports whether an activity has already been uploaded to a destination
+func (l *Ledger) IsUploaded(destination string, activityID int64) bool {
+	entry, ok := l.Get(destination, activityID)
+	return ok && entry.Status == StatusUploaded
+}
+
+// FindByHash returns a copy of the entry of a destination whose FIT file has
+// the given content hash, preferring uploaded entries. It identifies local
+// files, which have no Garmin activity ID, and files already synced from Garmin.
+func (l *Ledger) FindByHash(destination, fitHash string) (Entry, bool) {
+	if fitHash == "" {
+		return Entry{}, false
+	}
+
+	var found *Entry
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, entry := range l.entries {
+		if key.destination != destination || entry.FitHash != fitHash {
+			continue
+		}
+		if found == nil || entry.Status == StatusUploaded {
+			found = entry
+		}
+	}
+	if found == nil {
+		return Entry{}, false
+	}
+	return *found, true
+}
+
+// MarkUploaded records a successful upload and persists the ledger. Non-empty
+// fields of update are merged into the stored entry.
+func (l *Ledger) MarkUploaded(update Entry) error {
+	l.mu.Lock()
+	entry := l.touch(update)
+	entry.Status = StatusUploaded
+	entry.Error = ""
+	entry.UploadedAt = entry.UpdatedAt
+	l.mu.Unlock()
+
+	return l.Save()
+}
+
+// MarkFailed records a failed attempt and persists the ledger. The entry
+// stays in the failed queue until a later attempt succeeds.
+func (l *Ledger) MarkFailed(update Entry, cause error) error {
+	l.mu.Lock()
+	entry := l.touch(update)
+	entry.Status = StatusFailed
+	entry.Error = cause.Error()
+	l.mu.Unlock()
+
+	return l.Save()
+}
+
+// Failed returns the failed-upload queue: all entries whose last attempt failed
+func (l *Ledger) Failed() []Entry {
+	var failed []Entry
+	for _, entry := range l.Entries() {
+		if entry.Status == StatusFailed {
+			failed = append(failed, entry)
+		}
+	}
+	return failed
+}
+
+// touch returns the entry for an activity, creating it if needed, merges
+// the non-empty fields of update and bumps the attempt counter. Callers must
+// hold l.mu.
+func (l *Ledger) touch(update Entry) *Entry {
+	now := time.Now().UTC()
+	if update.Destination == "" {
+		update.Destination = DefaultDestination
+	}
+	key := entryKey{update.Destination, update.ActivityID}
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &Entry{ActivityID: update.ActivityID, Destination: update.Destination, FirstSeen: now}
+		l.entries[key] = entry
+	}
+
+	if update.ActivityName != "" {
+		entry.ActivityName = update.ActivityName
+	}
+	if update.ActivityType != "" {
+		entry.ActivityType = update.ActivityType
+	}
+	if !update.StartTime.IsZero() {
+		entry.StartTime = update.StartTime
+	}
+	if update.RemoteKey != "" {
+		entry.RemoteKey = update.RemoteKey
+	}
+	if update.FitHash != "" {
+		entry.FitHash = update.FitHash
+	}
+	if update.FitPath != "" {
+		entry.FitPath = update.FitPath
+	}
+
+	entry.Attempts++
+	entry.UpdatedAt = now
+	return entry
+}
+
+// Entries returns a copy of all entries sorted by activity ID and destination
+func (l *Ledger) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]Entry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].ActivityID != entries[j].ActivityID {
+			return entries[i].ActivityID < entries[j].ActivityID
+		}
+		return entries[i].Destination < entries[j].Destination
+	})
+	return entries
+}
+
+// Save writes the ledger to disk atomically
+func (l *Ledger) Save() error {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	data, err := json.MarshalIndent(l.Entries(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode ledger: %w", err)
+	}
+
+	if dir := filepath.Dir(l.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create ledger directory: %w", err)
+		}
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(l.path), ".ledger_*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temp ledger: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), l.path); err != nil {
+		return fmt.Errorf("failed to replace ledger: %w", err)
+	}
+
+	return nil
+}
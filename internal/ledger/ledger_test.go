@@ -0,0 +1,167 @@
+package ledger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLedgerRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "ledger.json")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open of a missing file: %v", err)
+	}
+	if len(l.Entries()) != 0 {
+		t.Fatalf("a missing file gave %d entries", len(l.Entries()))
+	}
+
+	start := time.Date(2025, 1, 15, 6, 15, 0, 0, time.UTC)
+	failed := Entry{ActivityID: 1, Destination: "ido", ActivityName: "Morning Ride", ActivityType: "road_biking",
+		StartTime: start, FitHash: "hash1", FitPath: "2025-01/ride.fit"}
+	if err := l.MarkFailed(failed, errors.New("upload timed out")); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	// A second attempt without details keeps the ones already recorded
+	if err := l.MarkFailed(Entry{ActivityID: 1, Destination: "ido"}, errors.New("HTTP 502")); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := l.Get("ido", 1)
+	if entry.Attempts != 2 || entry.Error != "HTTP 502" || entry.ActivityName != "Morning Ride" || entry.FitPath != "2025-01/ride.fit" {
+		t.Errorf("after two failures: %+v", entry)
+	}
+	if len(l.Failed()) != 1 || l.IsUploaded("ido", 1) {
+		t.Errorf("the failed activity is not queued: %+v", l.Failed())
+	}
+
+	if err := l.MarkUploaded(Entry{ActivityID: 1, Destination: "ido", RemoteKey: "uploads/1.fit"}); err != nil {
+		t.Fatalf("MarkUploaded: %v", err)
+	}
+	// The same activity on another destination is a separate entry, and an
+	// entry without destination is an iDO one
+	if err := l.MarkUploaded(Entry{ActivityID: 1, Destination: "folder", FitHash: "hash1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.MarkUploaded(Entry{ActivityID: 2, FitHash: "hash2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	entries := reopened.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("reopened ledger has %d entries, want 3: %+v", len(entries), entries)
+	}
+	if entries[0].Destination != "folder" || entries[1].Destination != "ido" || entries[2].ActivityID != 2 {
+		t.Errorf("entries are not sorted by activity and destination: %+v", entries)
+	}
+
+	entry, ok := reopened.Get("ido", 1)
+	if !ok {
+		t.Fatal("the iDO entry of activity 1 is missing")
+	}
+	if entry.Status != StatusUploaded || entry.Error != "" || entry.Attempts != 3 || entry.RemoteKey != "uploads/1.fit" {
+		t.Errorf("uploaded entry: %+v", entry)
+	}
+	if !entry.StartTime.Equal(start) || entry.FitHash != "hash1" || entry.UploadedAt.IsZero() || !entry.UploadedAt.Equal(entry.UpdatedAt) {
+		t.Errorf("uploaded entry lost details: %+v", entry)
+	}
+	if entry.FirstSeen.After(entry.UpdatedAt) {
+		t.Errorf("FirstSeen %s is after UpdatedAt %s", entry.FirstSeen, entry.UpdatedAt)
+	}
+	if !reopened.IsUploaded("ido", 2) || reopened.IsUploaded("folder", 2) {
+		t.Error("activity 2 was not recorded for iDO only")
+	}
+	if len(reopened.Failed()) != 0 {
+		t.Errorf("failed queue: %+v", reopened.Failed())
+	}
+}
+
+func TestFindByHash(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cause := errors.New("upload failed")
+
+	// Several entries share the file: a failed one and an uploaded one
+	for id := int64(1); id <= 5; id++ {
+		if err := l.MarkFailed(Entry{ActivityID: id, Destination: "ido", FitHash: "shared"}, cause); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.MarkUploaded(Entry{ActivityID: 3, Destination: "ido"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.MarkFailed(Entry{ActivityID: 6, Destination: "folder", FitHash: "other"}, cause); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry, ok := l.FindByHash("ido", "shared"); !ok || entry.ActivityID != 3 {
+		t.Errorf("FindByHash = %+v, %v, want the uploaded entry 3", entry, ok)
+	}
+	if entry, ok := l.FindByHash("folder", "other"); !ok || entry.Status != StatusFailed {
+		t.Errorf("FindByHash of a failed-only file = %+v, %v", entry, ok)
+	}
+	if _, ok := l.FindByHash("folder", "shared"); ok {
+		t.Error("FindByHash matched another destination")
+	}
+	if _, ok := l.FindByHash("ido", ""); ok {
+		t.Error("FindByHash matched an empty hash")
+	}
+}
+
+// legacyLedger is a ledger written before destinations were introduced
+const legacyLedger = `[
+  {"activityId": 7, "status": "uploaded", "s3Key": "uploads/7.fit", "attempts": 1},
+  {"activityId": 8, "status": "failed", "error": "HTTP 500", "attempts": 2},
+  {"activityId": 9, "destination": "folder", "status": "uploaded", "remoteKey": "2025/9.fit", "s3Key": "ignored", "attempts": 1}
+]`
+
+func TestOpenLegacyLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	if err := os.WriteFile(path, []byte(legacyLedger), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	entry, ok := l.Get(DefaultDestination, 7)
+	if !ok || entry.RemoteKey != "uploads/7.fit" || entry.Status != StatusUploaded {
+		t.Errorf("legacy entry 7 = %+v, %v", entry, ok)
+	}
+	if entry, ok := l.Get(DefaultDestination, 8); !ok || entry.Status != StatusFailed || entry.Attempts != 2 {
+		t.Errorf("legacy entry 8 = %+v, %v", entry, ok)
+	}
+	if entry, ok := l.Get("folder", 9); !ok || entry.RemoteKey != "2025/9.fit" {
+		t.Errorf("entry 9 = %+v, %v, want the remoteKey kept", entry, ok)
+	}
+
+	// Saving migrates the file to the current format
+	if err := l.Save(); err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := migrated.Get(DefaultDestination, 7); entry.RemoteKey != "uploads/7.fit" || entry.Destination != DefaultDestination {
+		t.Errorf("migrated entry 7 = %+v", entry)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	if err := os.WriteFile(path, []byte(`{"not": "a list"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("Open of an invalid ledger succeeded")
+	}
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"time"
+)
+
+// Upload steps of the iDO client, as "step" label values
+const (
+	StepGetUploadURL   = "get_upload_url"
+	StepS3Upload       = "s3_upload"
+	StepCreateActivity = "create_activity"
+)
+
+// Service names, as "service" label values of the session age
+const (
+	ServiceGarmin = "garmin"
+	ServiceIDO    = "ido"
+)
+
+// Session is implemented by clients that can tell when they last logged in
+type Session interface {
+	LoggedInAt() time.Time
+}
+
+// Set holds the metrics of the daemon. Every series has a "profile" label,
+// empty for a config file without profiles.
+type Set struct {
+	Registry *Registry
+
+	discovered  *Counter
+	synced      *Counter
+	failed      *Counter
+	download    *Histogram
+	fitSize     *Histogram
+	uploadStep  *Histogram
+	lastSuccess *Gauge
+	sessionAge  *Gauge
+}
+
+// NewSet registers the daemon metrics in a new registry
+func NewSet() *Set {
+	r := NewRegistry()
+	return &Set{
+		Registry: r,
+		discovered: r.NewCounter("garmin_to_ido_activities_discovered_total",
+			"Garmin activities found for the synced sports.", "profile", "sport"),
+		synced: r.NewCounter("garmin_to_ido_activities_synced_total",
+			"Activities uploaded to every destination.", "profile", "sport"),
+		failed: r.NewCounter("garmin_to_ido_activities_failed_total",
+			"Activities that failed to sync, by the step that failed.", "profile", "sport", "error_class"),
+		download: r.NewHistogram("garmin_to_ido_garmin_download_seconds",
+			"Time to download an activity from Garmin.",
+			[]float64{0.5, 1, 2, 5, 10, 20, 30, 60}, "profile"),
+		fitSize: r.NewHistogram("garmin_to_ido_fit_size_bytes",
+			"Size of the FIT files extracted from Garmin downloads.",
+			[]float64{16 << 10, 64 << 10, 256 << 10, 512 << 10, 1 << 20, 2 << 20, 4 << 20, 8 << 20}, "profile"),
+		uploadStep: r.NewHistogram("garmin_to_ido_ido_upload_step_seconds",
+			"Time of each step of an iDO upload.",
+			[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "profile", "step"),
+		lastSuccess: r.NewGauge("garmin_to_ido_last_successful_sync_timestamp_seconds",
+			"Unix time of the last sync run without failures.", "profile"),
+		sessionAge: r.NewGauge("garmin_to_ido_session_age_seconds",
+			"Time since the last login, by service.", "profile", "service"),
+	}
+}
+
+// Profile returns the recorder of one profile. A nil set returns a nil
+// recorder, which records nothing.
+func (s *Set) Profile(name string) *Recorder {
+	if s == nil {
+		return nil
+	}
+	return &Recorder{set: s, profile: name}
+}
+
+// Recorder records the metrics of one profile. All methods are no-ops on a
+// nil recorder, so metrics stay optional for callers.
+type Recorder struct {
+	set     *Set
+	profile string
+}
+
+// Discovered counts an activity found on Garmin
+func (r *Recorder) Discovered(sport string) {
+	if r == nil {
+		return
+	}
+	r.set.discovered.Inc(r.profile, sport)
+}
+
+// Synced counts an activity uploaded to every destination
+func (r *Recorder) Synced(sport string) {
+	if r == nil {
+		return
+	}
+	r.set.synced.Inc(r.profile, sport)
+}
+
+// Failed counts an activity that failed to sync
+func (r *Recorder) Failed(sport, errorClass string) {
+	if r == nil {
+		return
+	}
+	r.set.failed.Inc(r.profile, sport, errorClass)
+}
+
+// Download records the time taken by a Garmin download
+func (r *Recorder) Download(d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.set.download.Observe(d.Seconds(), r.profile)
+}
+
+// FitSize records the size of an extracted FIT file
+func (r *Recorder) FitSize(bytes int) {
+	if r == nil {
+		return
+	}
+	r.set.fitSize.Observe(float64(bytes), r.profile)
+}
+
+// UploadStep records the time taken by one step of an iDO upload
+func (r *Recorder) UploadStep(step string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.set.uploadStep.Observe(d.Seconds(), r.profile, step)
+}
+
+// SyncSucceeded records the time of a sync run without failures
+func (r *Recorder) SyncSucceeded(t time.Time) {
+	if r == nil {
+		return
+	}
+	r.set.lastSuccess.Set(float64(t.Unix()), r.profile)
+}
+
+// TrackSession exposes the age of a client's session, computed at scrape
+// time. A session that never logged in reports 0.
+func (r *Recorder) TrackSession(service string, session Session) {
+	if r == nil || session == nil {
+		return
+	}
+	r.set.sessionAge.SetFunc(func() float64 {
+		loggedInAt := session.LoggedInAt()
+		if loggedInAt.IsZero() {
+			return 0
+		}
+		return time.Since(loggedInAt).Seconds()
+	}, r.profile, service)
+}
@@ -0,0 +1,272 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	gosync "sync"
+)
+
+// Registry holds metric families and writes them in the Prometheus text
+// exposition format
+type Registry struct {
+	mu       gosync.Mutex
+	families []*family
+}
+
+// kind is the Prometheus type of a metric family
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// family is a metric and its labelled series
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	series map[string]*series
+	// funcs are gauges computed at scrape time, by label values key
+	funcs map[string]func() float64
+}
+
+// series is one labelled value of a family
+type series struct {
+	labelValues []string
+	value       float64
+	// counts, sum and count are only used by histograms
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	r *Registry
+	f *family
+}
+
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	r *Registry
+	f *family
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	r *Registry
+	f *family
+}
+
+// NewCounter registers a counter with the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r, r.register(name, help, kindCounter, labels, nil)}
+}
+
+// NewGauge registers a gauge with the given label names
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r, r.register(name, help, kindGauge, labels, nil)}
+}
+
+// NewHistogram registers a histogram with the given upper bounds (sorted)
+// and label names
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r, r.register(name, help, kindHistogram, labels, buckets)}
+}
+
+func (r *Registry) register(name, help string, k kind, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+		funcs:   make(map[string]func() float64),
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// Add increases the counter of the given label values
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.f.get(labelValues).value += delta
+}
+
+// Inc increases the counter of the given label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Set sets the gauge of the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	g.f.get(labelValues).value = value
+}
+
+// SetFunc makes the gauge of the given label values computed by fn at every
+// scrape. fn must not use the registry.
+func (g *Gauge) SetFunc(fn func() float64, labelValues ...string) {
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	g.f.get(labelValues)
+	g.f.funcs[seriesKey(labelValues)] = fn
+}
+
+// Observe adds a value to the histogram of the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+
+	s := h.f.get(labelValues)
+	for i, bound := range h.f.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// get returns the series of the given label values, creating it if needed
+func (f *family) get(labelValues []string) *series {
+	key := seriesKey(labelValues)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	cw := &countingWriter{w: buffered}
+	for _, f := range r.families {
+		f.write(cw)
+	}
+	return cw.n, buffered.Flush()
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func (f *family) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, helpEscaper.Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != kindHistogram {
+			value := s.value
+			if fn, ok := f.funcs[key]; ok {
+				value = fn()
+			}
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues, ""), formatValue(value))
+			continue
+		}
+
+		for i, bound := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, formatValue(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues, ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues, ""), s.count)
+	}
+}
+
+// formatLabels formats a label set, with the "le" label of histogram buckets
+// if le is set
+func formatLabels(names, values []string, le string) string {
+	var pairs []string
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name+`="`+escapeLabel(value)+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Escapers of label values and help texts, as the text format requires
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written, for WriteTo
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const registryGolden = `# HELP test_uploads_total Uploads, by path.
+# TYPE test_uploads_total counter
+test_uploads_total{path="C:\\fits\\ride.fit",note="a \"quoted\"\nname"} 1
+test_uploads_total{path="plain",note=""} 2.5
+# HELP test_queue_length Queued activities.\nSecond line with a \\.
+# TYPE test_queue_length gauge
+test_queue_length 7
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{step="a",le="0.1"} 0
+test_duration_seconds_bucket{step="a",le="1"} 0
+test_duration_seconds_bucket{step="a",le="5"} 0
+test_duration_seconds_bucket{step="a",le="+Inf"} 1
+test_duration_seconds_sum{step="a"} 60
+test_duration_seconds_count{step="a"} 1
+test_duration_seconds_bucket{step="b",le="0.1"} 1
+test_duration_seconds_bucket{step="b",le="1"} 3
+test_duration_seconds_bucket{step="b",le="5"} 4
+test_duration_seconds_bucket{step="b",le="+Inf"} 5
+test_duration_seconds_sum{step="b"} 104.25
+test_duration_seconds_count{step="b"} 5
+# HELP test_empty_total Never incremented.
+# TYPE test_empty_total counter
+`
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	uploads := r.NewCounter("test_uploads_total", "Uploads, by path.", "path", "note")
+	queue := r.NewGauge("test_queue_length", "Queued activities.\nSecond line with a \\.")
+	durations := r.NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1, 5}, "step")
+	r.NewCounter("test_empty_total", "Never incremented.")
+
+	uploads.Add(2.5, "plain")
+	uploads.Inc(`C:\fits\ride.fit`, "a \"quoted\"\nname")
+	queue.Set(3)
+	queue.SetFunc(func() float64 { return 7 })
+	for _, value := range []float64{0.1, 0.5, 0.75, 2.9, 100} {
+		durations.Observe(value, "b")
+	}
+	durations.Observe(60, "a")
+
+	var out bytes.Buffer
+	n, err := r.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("WriteTo returned %d bytes, wrote %d", n, out.Len())
+	}
+	if out.String() != registryGolden {
+		t.Errorf("WriteTo wrote:\n%s\nwant:\n%s", out.String(), registryGolden)
+	}
+}
+
+const setGolden = `garmin_to_ido_activities_synced_total{profile="",sport="bike"} 1
+garmin_to_ido_activities_synced_total{profile="alice",sport="bike"} 2
+garmin_to_ido_activities_synced_total{profile="alice",sport="run"} 1
+garmin_to_ido_activities_failed_total{profile="bob",sport="swim",error_class="upload"} 1
+garmin_to_ido_ido_upload_step_seconds_bucket{profile="bob",step="s3_upload",le="0.5"} 0
+garmin_to_ido_ido_upload_step_seconds_bucket{profile="bob",step="s3_upload",le="1"} 1
+garmin_to_ido_last_successful_sync_timestamp_seconds{profile="alice"} 1.7369253e+09
+garmin_to_ido_session_age_seconds{profile="bob",service="garmin"} 0
+`
+
+// loggedOut is a session that never logged in
+type loggedOut struct{}
+
+func (loggedOut) LoggedInAt() time.Time { return time.Time{} }
+
+func TestSetProfiles(t *testing.T) {
+	set := NewSet()
+	alice, bob := set.Profile("alice"), set.Profile("bob")
+	alice.Synced("bike")
+	alice.Synced("bike")
+	alice.Synced("run")
+	set.Profile("").Synced("bike")
+	bob.Failed("swim", "upload")
+	bob.UploadStep(StepS3Upload, 800*time.Millisecond)
+	alice.SyncSucceeded(time.Date(2025, 1, 15, 7, 15, 0, 0, time.UTC))
+	bob.TrackSession(ServiceGarmin, loggedOut{})
+
+	// A nil set records nothing
+	var none *Set
+	none.Profile("carol").Synced("bike")
+
+	recorder := httptest.NewRecorder()
+	set.Registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	want := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(setGolden), "\n") {
+		want[line] = true
+	}
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		delete(want, line)
+		if strings.Contains(line, "carol") {
+			t.Errorf("a nil set recorded %s", line)
+		}
+	}
+	for line := range want {
+		t.Errorf("missing series %s", line)
+	}
+}
@@ -0,0 +1,132 @@
+package naming
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"text/template"
+
+	"garmin-to-ido/internal/garmin"
+)
+
+// funcs are the helpers available in name templates
+var funcs = template.FuncMap{
+	// km formats a distance in meters as kilometers, e.g. "42.2"
+	"km": func(meters float64) string {
+		return fmt.Sprintf("%.1f", meters/1000)
+	},
+	// duration formats a duration in seconds, e.g. "1h05" or "45min"
+	"duration": func(seconds float64) string {
+		minutes := int(math.Round(seconds / 60))
+		if minutes < 60 {
+			return fmt.Sprintf("%dmin", minutes)
+		}
+		return fmt.Sprintf("%dh%02d", minutes/60, minutes%60)
+	},
+	// typeName turns a Garmin type key into words, e.g. "Indoor Cycling"
+	"typeName": func(typeKey string) string {
+		words := strings.Fields(strings.ReplaceAll(typeKey, "_", " "))
+		for i, word := range words {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+		return strings.Join(words, " ")
+	},
+}
+
+// Built-in templates, used for the sports that have no template when no
+// default one is configured: the workout name, or the type in words
+const (
+	distanceTemplate = `{{or .WorkoutName (typeName .ActivityType)}} {{km .Distance}} km, {{duration .Duration}}`
+	durationTemplate = `{{or .WorkoutName (typeName .ActivityType)}} {{duration .Duration}}`
+)
+
+// defaultSportTemplates are the built-in templates of each sport
+var defaultSportTemplates = map[garmin.Sport]string{
+	garmin.SportBike:     distanceTemplate,
+	garmin.SportRun:      distanceTemplate,
+	garmin.SportSwim:     distanceTemplate,
+	garmin.SportWalk:     distanceTemplate,
+	garmin.SportStrength: durationTemplate,
+	garmin.SportOther:    durationTemplate,
+}
+
+// Namer names uploaded activities from Go text/templates executed against
+// the garmin.Activity. A sport's template takes precedence over the default
+// one, which takes precedence over the built-in template of the sport.
+type Namer struct {
+	fallback *template.Template
+	sports   map[garmin.Sport]*template.Template
+}
+
+// New parses the default template and the per-sport templates. Empty
+// templates are ignored.
+func New(defaultTemplate string, sportTemplates map[garmin.Sport]string) (*Namer, error) {
+	n := &Namer{sports: make(map[garmin.Sport]*template.Template)}
+
+	if defaultTemplate != "" {
+		tmpl, err := template.New("name").Funcs(funcs).Parse(defaultTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name template: %w", err)
+		}
+		n.fallback = tmpl
+	}
+
+	for sport, text := range sportTemplates {
+		if text == "" {
+			continue
+		}
+		tmpl, err := template.New("name_" + string(sport)).Funcs(funcs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s name template: %w", sport, err)
+		}
+		n.sports[sport] = tmpl
+	}
+
+	return n, nil
+}
+
+// builtinTemplates are the parsed defaultSportTemplates
+var builtinTemplates = func() map[garmin.Sport]*template.Template {
+	templates := make(map[garmin.Sport]*template.Template)
+	for sport, text := range defaultSportTemplates {
+		templates[sport] = template.Must(template.New("name_" + string(sport)).Funcs(funcs).Parse(text))
+	}
+	return templates
+}()
+
+// Enabled reports whether activities are renamed. A nil Namer keeps the
+// Garmin names.
+func (n *Namer) Enabled() bool {
+	return n != nil
+}
+
+// Name renders the name of an activity. The Garmin name is returned when no
+// template applies or when the template renders to nothing.
+func (n *Namer) Name(activity garmin.Activity) (string, error) {
+	if n == nil {
+		return activity.ActivityName, nil
+	}
+
+	tmpl, ok := n.sports[activity.Sport()]
+	if !ok {
+		tmpl = n.fallback
+	}
+	if tmpl == nil {
+		tmpl = builtinTemplates[activity.Sport()]
+	}
+	if tmpl == nil {
+		return activity.ActivityName, nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, activity); err != nil {
+		return activity.ActivityName, fmt.Errorf("failed to render activity name: %w", err)
+	}
+
+	name := strings.Join(strings.Fields(buf.String()), " ")
+	if name == "" {
+		return activity.ActivityName, nil
+	}
+	return name, nil
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"garmin-to-ido/internal/sync"
+)
+
+// commandTimeout bounds the run time of a notification command
+const commandTimeout = time.Minute
+
+// Command runs a shell command with the JSON report on its standard input.
+// The totals are also passed as environment variables: GARMIN_TO_IDO_PROFILE,
+// GARMIN_TO_IDO_SYNCED, GARMIN_TO_IDO_SKIPPED, GARMIN_TO_IDO_FAILED and
+// GARMIN_TO_IDO_SUBJECT.
+type Command struct {
+	Command string
+}
+
+// NewCommand creates a command notifier
+func NewCommand(command string) *Command {
+	return &Command{Command: command}
+}
+
+// Name implements Notifier
+func (c *Command) Name() string {
+	return "command"
+}
+
+// Notify implements Notifier. A non-zero exit status is an error.
+func (c *Command) Notify(report *sync.Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", c.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", c.Command)
+	}
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"GARMIN_TO_IDO_PROFILE="+report.Profile,
+		"GARMIN_TO_IDO_SYNCED="+strconv.Itoa(report.Synced),
+		"GARMIN_TO_IDO_SKIPPED="+strconv.Itoa(report.Skipped),
+		"GARMIN_TO_IDO_FAILED="+strconv.Itoa(report.Failed),
+		"GARMIN_TO_IDO_SUBJECT="+Subject(report),
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if output = bytes.TrimSpace(output); len(output) > 0 {
+			return fmt.Errorf("command failed: %w: %s", err, output)
+		}
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"garmin-to-ido/internal/sync"
+)
+
+// defaultSMTPPort is the submission port, with STARTTLS
+const defaultSMTPPort = 587
+
+// Email sends the text summary of a report by SMTP. The connection is
+// upgraded with STARTTLS when the server offers it; credentials are only sent
+// over TLS.
+type Email struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Name implements Notifier
+func (e *Email) Name() string {
+	return "email"
+}
+
+// Notify implements Notifier
+func (e *Email) Notify(report *sync.Report) error {
+	port := e.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+
+	if err := smtp.SendMail(addr, auth, e.From, e.To, e.message(report)); err != nil {
+		return fmt.Errorf("failed to send email through %s: %w", addr, err)
+	}
+	return nil
+}
+
+// message builds the email, headers included
+func (e *Email) message(report *sync.Report) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", Subject(report)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(Summary(report), "\n", "\r\n"))
+	return msg.Bytes()
+}
@@ -0,0 +1,169 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"garmin-to-ido/internal/sync"
+)
+
+// Notifier delivers the report of a finished sync run
+type Notifier interface {
+	// Name identifies the notifier in log messages ("webhook", "email", ...)
+	Name() string
+	// Notify sends the report
+	Notify(report *sync.Report) error
+}
+
+// Trigger decides which runs are notified
+type Trigger string
+
+const (
+	// TriggerFailures notifies runs where something failed
+	TriggerFailures Trigger = "failures"
+	// TriggerChanges notifies runs where an activity was synced or failed
+	TriggerChanges Trigger = "changes"
+	// TriggerAlways notifies every run
+	TriggerAlways Trigger = "always"
+)
+
+// Options holds the raw notification settings, as found in the configuration.
+// A notifier is enabled by setting its target (URL, SMTP host, command).
+type Options struct {
+	On string
+
+	WebhookURL string
+
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	SMTPTo       []string
+
+	Command string
+}
+
+// Dispatcher sends sync reports to every configured notifier
+type Dispatcher struct {
+	on        Trigger
+	notifiers []Notifier
+}
+
+// New validates the options and builds a dispatcher. It returns nil when no
+// notifier is configured.
+func New(opts Options) (*Dispatcher, error) {
+	d := &Dispatcher{on: TriggerFailures}
+	switch on := Trigger(strings.ToLower(opts.On)); on {
+	case "":
+	case TriggerFailures, TriggerChanges, TriggerAlways:
+		d.on = on
+	default:
+		return nil, fmt.Errorf("invalid notification trigger %q (use failures, changes or always)", opts.On)
+	}
+
+	if opts.WebhookURL != "" {
+		d.notifiers = append(d.notifiers, NewWebhook(opts.WebhookURL))
+	}
+	if opts.SMTPHost != "" {
+		if opts.SMTPFrom == "" || len(opts.SMTPTo) == 0 {
+			return nil, fmt.Errorf("email notifications need a sender and at least one recipient")
+		}
+		d.notifiers = append(d.notifiers, &Email{
+			Host:     opts.SMTPHost,
+			Port:     opts.SMTPPort,
+			Username: opts.SMTPUsername,
+			Password: opts.SMTPPassword,
+			From:     opts.SMTPFrom,
+			To:       opts.SMTPTo,
+		})
+	}
+	if opts.Command != "" {
+		d.notifiers = append(d.notifiers, NewCommand(opts.Command))
+	}
+
+	if len(d.notifiers) == 0 {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// Send notifies the report if it matches the trigger. Dry runs are never
+// notified. Delivery errors are logged, a failing notifier doesn't stop the
+// others. A nil dispatcher does nothing.
+func (d *Dispatcher) Send(report *sync.Report) {
+	if d == nil || report == nil || report.DryRun || !d.matches(report) {
+		return
+	}
+	for _, notifier := range d.notifiers {
+		if err := notifier.Notify(report); err != nil {
+			log.Printf("✗ Failed to send %s notification: %v", notifier.Name(), err)
+		}
+	}
+}
+
+// matches reports whether the report should be notified
+func (d *Dispatcher) matches(report *sync.Report) bool {
+	switch d.on {
+	case TriggerAlways:
+		return true
+	case TriggerChanges:
+		return report.Synced > 0 || report.HasFailures()
+	default:
+		return report.HasFailures()
+	}
+}
+
+// Subject summarizes a report in one line
+func Subject(report *sync.Report) string {
+	var subject strings.Builder
+	subject.WriteString("garmin-to-ido")
+	if report.Profile != "" {
+		fmt.Fprintf(&subject, " [%s]", report.Profile)
+	}
+	switch {
+	case report.Error != "":
+		fmt.Fprintf(&subject, ": sync failed (%s)", report.ErrorClass)
+	case report.Failed > 0:
+		fmt.Fprintf(&subject, ": %d activity(ies) failed, %d synced", report.Failed, report.Synced)
+	default:
+		fmt.Fprintf(&subject, ": %d activity(ies) synced", report.Synced)
+	}
+	return subject.String()
+}
+
+// Summary describes a report as plain text, one line per activity
+func Summary(report *sync.Report) string {
+	var summary strings.Builder
+	if report.From != "" {
+		fmt.Fprintf(&summary, "Sync of %s to %s\n", report.From, report.To)
+	}
+	if report.Error != "" {
+		fmt.Fprintf(&summary, "✗ %s error: %s\n", report.ErrorClass, report.Error)
+	}
+	for _, warning := range report.Warnings {
+		fmt.Fprintf(&summary, "! %s\n", warning)
+	}
+
+	for _, result := range report.Activities {
+		mark := "✓"
+		switch result.Status {
+		case sync.StatusFailed:
+			mark = "✗"
+		case sync.StatusSkipped:
+			mark = "-"
+		}
+		fmt.Fprintf(&summary, "%s %s  %s (%s): %s", mark, result.StartTime.Format("2006-01-02 15:04"), result.Name, result.Type, result.Status)
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(&summary, " - %s: %s", result.ErrorClass, result.Error)
+		case result.Reason != "":
+			fmt.Fprintf(&summary, " - %s", result.Reason)
+		}
+		summary.WriteString("\n")
+	}
+
+	fmt.Fprintf(&summary, "%d synced, %d skipped, %d failed\n", report.Synced, report.Skipped, report.Failed)
+	return summary.String()
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"garmin-to-ido/internal/sync"
+)
+
+// Webhook posts the JSON report, the same as `-report json` prints, to a URL
+type Webhook struct {
+	URL    string
+	client *http.Client
+}
+
+// NewWebhook creates a webhook notifier
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Name implements Notifier
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Notify implements Notifier. Any non-2xx response is an error.
+func (w *Webhook) Notify(report *sync.Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "garmin-to-ido")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post report: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
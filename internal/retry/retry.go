@@ -0,0 +1,56 @@
+package retry
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Policy describes how many times and how fast an operation is retried
+type Policy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// transient is implemented by errors that know whether they are worth retrying
+type transient interface {
+	Transient() bool
+}
+
+// IsTransient reports whether err is a temporary failure: either the error
+// says so itself, or it is a network error
+func IsTransient(err error) bool {
+	var t transient
+	if errors.As(err, &t) {
+		return t.Transient()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Do runs fn until it succeeds, fails with a non-transient error or the
+// policy runs out of attempts. The delay doubles after every attempt, up to
+// MaxDelay. onRetry, if set, is called before each new attempt.
+func Do(policy Policy, fn func() error, onRetry func(attempt int, delay time.Duration, err error)) error {
+	delay := policy.InitialDelay
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !IsTransient(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		if onRetry != nil {
+			onRetry(attempt+1, delay, err)
+		}
+		time.Sleep(delay)
+
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/retry"
+)
+
+// Export downloads the activities of the given sports (all if empty) between
+// two dates (inclusive) into dir, in the given format. Destinations are not
+// used. Files already in dir are kept, so exporting the same range again only
+// downloads new activities.
+func (s *Syncer) Export(start, end time.Time, dir string, format garmin.Format, sports []garmin.Sport) (*Report, error) {
+	report := newReport(start, end, false)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		err = fmt.Errorf("failed to create export directory: %w", err)
+		report.fail(ErrorExtract, err)
+		report.finish(nil)
+		return report, err
+	}
+
+	allActivities, err := s.getActivities(start, end)
+	if err != nil {
+		err = fmt.Errorf("failed to get activities: %w", err)
+		report.fail(classify(ErrorList, err), err)
+		report.finish(nil)
+		return report, err
+	}
+
+	var activities []garmin.Activity
+	for _, activity := range allActivities {
+		if len(sports) == 0 || slices.Contains(sports, activity.Sport()) {
+			activities = append(activities, activity)
+		}
+	}
+
+	if len(activities) == 0 {
+		fmt.Fprintf(s.out, "  No activities found for %s\n", formatRange(start, end))
+		report.finish(nil)
+		return report, nil
+	}
+
+	fmt.Fprintf(s.out, "  Found %d activity(ies)\n", len(activities))
+
+	results := make([]ActivityResult, 0, len(activities))
+	for i, activity := range activities {
+		fmt.Fprintf(s.out, "  [%d/%d] %s (%s, %s)\n",
+			i+1, len(activities),
+			activity.ActivityName,
+			activity.ActivityType,
+			activity.StartTime.Format("2006-01-02 15:04"),
+		)
+		results = append(results, s.exportActivity(activity, dir, format))
+	}
+
+	report.finish(results)
+	fmt.Fprintf(s.out, "  %d of %d activity(ies) exported to %s\n", report.Synced, len(activities), dir)
+	return report, nil
+}
+
+// exportActivity downloads one activity into dir
+func (s *Syncer) exportActivity(activity garmin.Activity, dir string, format garmin.Format) ActivityResult {
+	result := newResult(activity)
+	path := filepath.Join(dir, fmt.Sprintf("%s_%d_%s%s",
+		activity.StartTime.Format("20060102_150405"), activity.ActivityID, activity.ActivityType, format.Extension()))
+	result.File = path
+
+	if _, err := os.Stat(path); err == nil {
+		fmt.Fprintf(s.out, "    ✓ Already exported, skipping\n")
+		result.Status = StatusSkipped
+		result.Reason = "already exported"
+		return result
+	}
+
+	started := time.Now()
+	var data []byte
+	err := retry.Do(s.opts.Retry, func() error {
+		s.garminLimiter.Wait()
+		var err error
+		data, err = s.garminClient.DownloadActivity(activity.ActivityID, format)
+		return err
+	}, func(attempt int, delay time.Duration, err error) {
+		fmt.Fprintf(s.out, "    ↻ %v - retrying in %s (attempt %d)\n", err, delay, attempt)
+	})
+	result.DownloadTime = Duration(time.Since(started))
+	if err != nil {
+		fmt.Fprintf(s.out, "    ✗ Failed to download: %v\n", err)
+		result.Status = StatusFailed
+		result.ErrorClass = classify(ErrorDownload, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	// The original file comes as a ZIP, keep only the FIT file
+	if format == garmin.FormatFIT {
+		if _, data, err = garmin.ExtractFIT(data); err != nil {
+			fmt.Fprintf(s.out, "    ✗ Failed to extract FIT file: %v\n", err)
+			result.Status = StatusFailed
+			result.ErrorClass = ErrorExtract
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	// Write through a temporary file so an interrupted export is retried
+	if err := writeFileAtomic(path, data); err != nil {
+		fmt.Fprintf(s.out, "    ✗ Failed to write file: %v\n", err)
+		result.Status = StatusFailed
+		result.ErrorClass = ErrorExtract
+		result.Error = err.Error()
+		return result
+	}
+
+	fmt.Fprintf(s.out, "    ✓ Exported to %s (%d bytes)\n", path, len(data))
+	result.Status = StatusSynced
+	result.Bytes = len(data)
+	return result
+}
+
+// writeFileAtomic writes a file under a temporary name and renames it
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".part"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"garmin-to-ido/internal/archive"
+	"garmin-to-ido/internal/destination"
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/ledger"
+	"garmin-to-ido/internal/retry"
+)
+
+// job carries one activity through the download, extract and upload stages.
+// All progress for the activity is written to out and printed once the job
+// is finished, so the report stays in activity order whatever the concurrency.
+type job struct {
+	index    int
+	activity garmin.Activity
+	out      bytes.Buffer
+	result   ActivityResult
+
+	// targets are the destinations the activity still has to be uploaded to
+	targets []destination.Destination
+
+	// name is the activity name sent to destinations
+	name string
+
+	zipData []byte
+	fitData []byte
+	fitHash string
+	fitPath string
+}
+
+// runPipeline downloads, extracts and uploads the activities using bounded
+// worker pools for each stage and returns the results in activity order
+func (s *Syncer) runPipeline(activities []garmin.Activity, debug bool) []ActivityResult {
+	downloads := make(chan *job)
+	extracts := make(chan *job)
+	uploads := make(chan *job)
+	finished := make(chan *job, len(activities))
+
+	// Download stage
+	var downloadWG sync.WaitGroup
+	for i := 0; i < workerCount(s.opts.DownloadConcurrency); i++ {
+		downloadWG.Add(1)
+		go func() {
+			defer downloadWG.Done()
+			for j := range downloads {
+				if s.download(j) {
+					extracts <- j
+				} else {
+					finished <- j
+				}
+			}
+		}()
+	}
+
+	// Extract stage (local work only, a single worker keeps up)
+	var extractWG sync.WaitGroup
+	extractWG.Add(1)
+	go func() {
+		defer extractWG.Done()
+		for j := range extracts {
+			if s.extract(j) {
+				uploads <- j
+			} else {
+				finished <- j
+			}
+		}
+	}()
+
+	// Upload stage
+	var uploadWG sync.WaitGroup
+	for i := 0; i < workerCount(s.opts.UploadConcurrency); i++ {
+		uploadWG.Add(1)
+		go func() {
+			defer uploadWG.Done()
+			for j := range uploads {
+				s.upload(j, debug)
+				finished <- j
+			}
+		}()
+	}
+
+	// Feed the pipeline, skipping activities the ledger already has
+	go func() {
+		for i, activity := range activities {
+			j := &job{index: i, activity: activity, result: newResult(activity)}
+			fmt.Fprintf(&j.out, "  [%d/%d] %s (%.2f km, %.0f min)\n",
+				i+1, len(activities),
+				activity.ActivityName,
+				activity.Distance/1000,
+				activity.Duration/60,
+			)
+			j.name = s.uploadName(activity, &j.out)
+
+			for _, dest := range s.destinations {
+				if s.alreadyUploaded(dest, activity) {
+					j.result.Destinations = append(j.result.Destinations, DestinationResult{
+						Destination: dest.Name(),
+						Status:      StatusSkipped,
+						Reason:      "already synced",
+					})
+					continue
+				}
+				if remote, ok := s.alreadyPresent(dest, activity); ok {
+					s.recordPresent(j, dest, remote)
+					continue
+				}
+				j.targets = append(j.targets, dest)
+			}
+
+			if len(j.targets) == 0 {
+				j.result.Status = StatusSkipped
+				j.result.Reason = skipReason(j.result.Destinations)
+				if j.result.Reason == "already synced" {
+					fmt.Fprintf(&j.out, "    ✓ Already synced (activity %d), skipping\n", activity.ActivityID)
+				}
+				finished <- j
+				continue
+			}
+			downloads <- j
+		}
+		close(downloads)
+		downloadWG.Wait()
+		close(extracts)
+		extractWG.Wait()
+		close(uploads)
+		uploadWG.Wait()
+		close(finished)
+	}()
+
+	// Print finished jobs in activity order
+	results := make([]ActivityResult, 0, len(activities))
+	pending := make(map[int]*job)
+	next := 0
+	for j := range finished {
+		pending[j.index] = j
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			s.out.Write(ready.out.Bytes())
+			results = append(results, ready.result)
+			delete(pending, next)
+			next++
+		}
+	}
+
+	return results
+}
+
+// download fetches the activity ZIP from Garmin
+func (s *Syncer) download(j *job) bool {
+	started := time.Now()
+	defer func() { j.result.DownloadTime = Duration(time.Since(started)) }()
+
+	// Download activity data (this is a ZIP file from Garmin). Every attempt
+	// goes through the rate limiter, retries included.
+	var zipData []byte
+	err := retry.Do(s.opts.Retry, func() error {
+		s.garminLimiter.Wait()
+		var err error
+		zipData, err = s.garminClient.DownloadActivity(j.activity.ActivityID, garmin.FormatFIT)
+		return err
+	}, j.logRetry)
+	if err != nil {
+		fmt.Fprintf(&j.out, "    ✗ Failed to download: %v\n", err)
+		s.recordFailure(j, ErrorDownload, err)
+		return false
+	}
+
+	j.zipData = zipData
+	s.opts.Metrics.Download(time.Since(started))
+	return true
+}
+
+// extract pulls the FIT file out of the ZIP and archives both
+func (s *Syncer) extract(j *job) bool {
+	activity := j.activity
+
+	// Extract the FIT file from the ZIP
+	fitFilename, fitData, err := garmin.ExtractFIT(j.zipData)
+	if err != nil {
+		fmt.Fprintf(&j.out, "    ✗ Failed to extract FIT file: %v\n", err)
+		s.recordFailure(j, ErrorExtract, err)
+		return false
+	}
+
+	fmt.Fprintf(&j.out, "    → Extracted FIT file: %s (%d bytes)\n", fitFilename, len(fitData))
+
+	j.fitData = fitData
+	j.result.Bytes = len(fitData)
+	j.fitHash = archive.Hash(fitData)
+	s.opts.Metrics.FitSize(len(fitData))
+
+	if s.opts.Archive == nil {
+		return true
+	}
+
+	// Archive both ZIP and FIT files so failed uploads can be replayed
+	record, stored, err := s.opts.Archive.Store(archive.Activity{
+		ActivityID:   activity.ActivityID,
+		ActivityName: activity.ActivityName,
+		ActivityType: activity.ActivityType,
+		StartTime:    activity.StartTime,
+		FitData:      fitData,
+		ZipData:      j.zipData,
+	})
+	if err != nil {
+		fmt.Fprintf(&j.out, "    ✗ Failed to archive FIT file: %v\n", err)
+		return true
+	}
+
+	j.fitPath = s.opts.Archive.Path(record.FitFile)
+	if stored {
+		fmt.Fprintf(&j.out, "    → Archived FIT file: %s\n", j.fitPath)
+	} else {
+		fmt.Fprintf(&j.out, "    → FIT file already archived: %s\n", j.fitPath)
+	}
+
+	return true
+}
+
+// upload sends the extracted FIT data to every target destination and
+// records the results
+func (s *Syncer) upload(j *job, debug bool) {
+	started := time.Now()
+	defer func() { j.result.UploadTime = Duration(time.Since(started)) }()
+
+	failed := false
+	for _, dest := range j.targets {
+		result := s.uploadTo(dest, j, debug)
+		j.result.Destinations = append(j.result.Destinations, result)
+		if result.Status == StatusFailed {
+			failed = true
+			j.result.ErrorClass = result.ErrorClass
+			j.result.Error = result.Error
+		}
+	}
+
+	if failed {
+		j.result.Status = StatusFailed
+		return
+	}
+	j.result.Status = StatusSynced
+	fmt.Fprintf(&j.out, "    ✓ Synced successfully\n")
+}
+
+// uploadTo uploads the extracted FIT data (not the ZIP) to one destination
+func (s *Syncer) uploadTo(dest destination.Destination, j *job, debug bool) DestinationResult {
+	result := DestinationResult{Destination: dest.Name()}
+	upload := j.destinationUpload(&j.out, debug)
+
+	started := time.Now()
+	remoteKey, err := s.uploadRetrying(dest, upload, false, j.logRetry)
+	result.UploadTime = Duration(time.Since(started))
+
+	entry := j.ledgerEntry(dest.Name())
+	if err != nil {
+		fmt.Fprintf(&j.out, "    ✗ Failed to upload to %s: %v\n", dest.Name(), err)
+		result.Status = StatusFailed
+		result.ErrorClass = classify(ErrorUpload, err)
+		result.Error = err.Error()
+		s.markFailed(j, entry, err)
+		return result
+	}
+
+	result.Status = StatusSynced
+	result.RemoteKey = remoteKey
+	if s.opts.Ledger != nil {
+		entry.RemoteKey = remoteKey
+		if err := s.opts.Ledger.MarkUploaded(entry); err != nil {
+			fmt.Fprintf(&j.out, "    ✗ Failed to update ledger: %v\n", err)
+		}
+	}
+
+	return result
+}
+
+// uploadRetrying uploads to dest, retrying transient failures. An attempt
+// that failed may still have stored the activity, e.g. when iDO times out
+// after creating it, so the destination is checked before each new attempt,
+// and before the first one too if checkFirst is set. If the activity is
+// there, it is not uploaded again. Every upload goes through the rate limiter
+// of dest, retries included.
+func (s *Syncer) uploadRetrying(dest destination.Destination, upload destination.Upload, checkFirst bool, onRetry func(int, time.Duration, error)) (string, error) {
+	var remoteKey string
+	attempt := 0
+	err := retry.Do(s.opts.Retry, func() error {
+		attempt++
+		if attempt > 1 || checkFirst {
+			key, found, err := remoteCopy(dest, upload)
+			if err != nil {
+				return fmt.Errorf("failed to check %s before uploading again: %w", dest.Name(), err)
+			}
+			if found {
+				fmt.Fprintf(upload.Output, "    ✓ Already in %s, not uploading again\n", destinationLabel(dest.Name()))
+				remoteKey = key
+				return nil
+			}
+		}
+
+		s.uploadLimiters[dest.Name()].Wait()
+		var err error
+		remoteKey, err = dest.Upload(upload)
+		return err
+	}, onRetry)
+	return remoteKey, err
+}
+
+// remoteCopy looks for the activity on dest and returns its remote key if
+// the destination can list its activities
+func remoteCopy(dest destination.Destination, upload destination.Upload) (string, bool, error) {
+	lister, ok := dest.(destination.Lister)
+	if !ok {
+		found, err := dest.Exists(upload)
+		return "", found, err
+	}
+
+	remote, err := lister.ListActivities(upload.StartTime, upload.StartTime)
+	if err != nil {
+		return "", false, err
+	}
+	match, found := destination.FindMatch(remote, upload)
+	return match.ID, found, nil
+}
+
+// recordPresent skips a destination that already has the activity, e.g.
+// because it was uploaded manually, and records it in the ledger so later
+// runs skip it without listing the destination again
+func (s *Syncer) recordPresent(j *job, dest destination.Destination, remote destination.RemoteActivity) {
+	label := destinationLabel(dest.Name())
+	fmt.Fprintf(&j.out, "    ✓ Already in %s (%s activity %s), skipping\n", label, label, remote.ID)
+	j.result.Destinations = append(j.result.Destinations, DestinationResult{
+		Destination: dest.Name(),
+		Status:      StatusSkipped,
+		Reason:      "already in " + label,
+		RemoteKey:   remote.ID,
+	})
+
+	if s.opts.Ledger == nil {
+		return
+	}
+	entry := j.ledgerEntry(dest.Name())
+	entry.RemoteKey = remote.ID
+	if err := s.opts.Ledger.MarkUploaded(entry); err != nil {
+		fmt.Fprintf(&j.out, "    ✗ Failed to update ledger: %v\n", err)
+	}
+}
+
+// skipReason summarizes why every destination was skipped
+func skipReason(results []DestinationResult) string {
+	reason := "already synced"
+	for i, result := range results {
+		if i > 0 && result.Reason != reason {
+			return "already synced"
+		}
+		reason = result.Reason
+	}
+	return reason
+}
+
+// recordFailure marks the job as failed before any upload happened and adds
+// the activity to the failed-upload queue of every target destination
+func (s *Syncer) recordFailure(j *job, class ErrorClass, cause error) {
+	j.result.Status = StatusFailed
+	j.result.ErrorClass = classify(class, cause)
+	j.result.Error = cause.Error()
+
+	for _, dest := range j.targets {
+		s.markFailed(j, j.ledgerEntry(dest.Name()), cause)
+	}
+}
+
+// markFailed adds an entry to the failed-upload queue, if a ledger is configured
+func (s *Syncer) markFailed(j *job, entry ledger.Entry, cause error) {
+	if s.opts.Ledger == nil {
+		return
+	}
+	if err := s.opts.Ledger.MarkFailed(entry, cause); err != nil {
+		fmt.Fprintf(&j.out, "    ✗ Failed to update ledger: %v\n", err)
+	}
+	if j.fitPath != "" {
+		fmt.Fprintf(&j.out, "    → Queued for retry-failed (%s)\n", entry.Destination)
+	}
+}
+
+// ledgerEntry describes the job's activity for the ledger
+func (j *job) ledgerEntry(destName string) ledger.Entry {
+	return ledger.Entry{
+		ActivityID:   j.activity.ActivityID,
+		Destination:  destName,
+		ActivityName: j.name,
+		ActivityType: j.activity.ActivityType,
+		StartTime:    j.activity.StartTime,
+		FitHash:      j.fitHash,
+		FitPath:      j.fitPath,
+	}
+}
+
+// destinationUpload describes the job's activity for a destination
+func (j *job) destinationUpload(out io.Writer, debug bool) destination.Upload {
+	return destination.Upload{
+		ActivityID:   j.activity.ActivityID,
+		ActivityName: j.name,
+		ActivityType: j.activity.ActivityType,
+		StartTime:    j.activity.StartTime,
+		Duration:     j.activity.Duration,
+		Distance:     j.activity.Distance,
+		FitData:      j.fitData,
+		Output:       out,
+		Debug:        debug,
+	}
+}
+
+// logRetry reports a transient failure before the next attempt
+func (j *job) logRetry(attempt int, delay time.Duration, err error) {
+	fmt.Fprintf(&j.out, "    ↻ %v - retrying in %s (attempt %d)\n", err, delay, attempt)
+}
+
+// workerCount returns the number of workers for a stage, at least one
+func workerCount(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
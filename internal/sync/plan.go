@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"fmt"
+
+	"garmin-to-ido/internal/destination"
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/ido"
+	"garmin-to-ido/internal/ledger"
+)
+
+// printPlan lists what a sync would do with the given activities without
+// downloading or uploading anything
+func (s *Syncer) printPlan(activities []garmin.Activity) []ActivityResult {
+	fmt.Fprintf(s.out, "  Dry run: nothing will be downloaded or uploaded\n")
+
+	results := make([]ActivityResult, 0, len(activities))
+	toUpload := 0
+	for i, activity := range activities {
+		fmt.Fprintf(s.out, "  [%d/%d] %s  %d  %q\n",
+			i+1, len(activities),
+			activity.StartTime.Format("2006-01-02 15:04"),
+			activity.ActivityID,
+			activity.ActivityName,
+		)
+		fmt.Fprintf(s.out, "    type: %s → iDO sport: %s\n", activity.ActivityType, ido.MapActivityType(activity.ActivityType))
+		if s.opts.Namer.Enabled() {
+			fmt.Fprintf(s.out, "    name: %q\n", s.uploadName(activity, s.out))
+		}
+
+		result := newResult(activity)
+		result.Status = StatusSkipped
+		result.Reason = "already synced"
+		for _, dest := range s.destinations {
+			status, upload := s.planStatus(dest, activity)
+			fmt.Fprintf(s.out, "    → %s: %s\n", dest.Name(), status)
+
+			destResult := DestinationResult{Destination: dest.Name(), Status: StatusSkipped, Reason: status}
+			if upload {
+				destResult.Status = StatusPlanned
+				result.Status = StatusPlanned
+				result.Reason = ""
+			}
+			result.Destinations = append(result.Destinations, destResult)
+		}
+
+		if result.Status == StatusPlanned {
+			toUpload++
+		}
+		results = append(results, result)
+	}
+
+	fmt.Fprintf(s.out, "  %d of %d activity(ies) would be uploaded\n", toUpload, len(activities))
+	return results
+}
+
+// planStatus describes what would happen to an activity for a destination
+// during a real sync and whether it would be uploaded
+func (s *Syncer) planStatus(dest destination.Destination, activity garmin.Activity) (string, bool) {
+	if s.opts.Ledger == nil {
+		return "upload", true
+	}
+
+	entry, ok := s.opts.Ledger.Get(dest.Name(), activity.ActivityID)
+	switch {
+	case !ok:
+		return "upload", true
+	case entry.Status == ledger.StatusUploaded && s.opts.Force[activity.ActivityID]:
+		return fmt.Sprintf("upload again (forced, already uploaded on %s)", entry.UploadedAt.Format("2006-01-02")), true
+	case entry.Status == ledger.StatusUploaded:
+		return "skip: already synced", false
+	default:
+		return fmt.Sprintf("upload (retry, %d failed attempt(s): %s)", entry.Attempts, entry.Error), true
+	}
+}
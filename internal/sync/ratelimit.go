@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces out calls to a service so that at most a given number
+// of requests per minute are issued, whatever the number of workers
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter creates a limiter allowing perMinute calls per minute.
+// Zero or a negative value disables limiting and returns nil.
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// Wait blocks until the caller is allowed to issue its next request
+func (r *rateLimiter) Wait() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mu.Unlock()
+
+	time.Sleep(wait)
+}
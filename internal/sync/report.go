@@ -0,0 +1,185 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/ido"
+)
+
+// Status is the outcome of a single activity in a sync run
+type Status string
+
+const (
+	StatusSynced  Status = "synced"
+	StatusSkipped Status = "skipped"
+	StatusFailed  Status = "failed"
+	StatusPlanned Status = "planned"
+)
+
+// ErrorClass tells at which point an activity (or a whole run) failed
+type ErrorClass string
+
+const (
+	ErrorAuth ErrorClass = "auth"
+	// ErrorConfig is a configuration the run could not start with
+	ErrorConfig ErrorClass = "config"
+	// ErrorReauth is an auth failure only an interactive login can solve
+	// (garmin-to-ido garmin-login)
+	ErrorReauth   ErrorClass = "reauth_required"
+	ErrorList     ErrorClass = "list"
+	ErrorDownload ErrorClass = "download"
+	ErrorExtract  ErrorClass = "extract"
+	ErrorUpload   ErrorClass = "upload"
+)
+
+// DestinationResult describes what happened to one activity for one destination
+type DestinationResult struct {
+	Destination string     `json:"destination"`
+	Status      Status     `json:"status"`
+	Reason      string     `json:"reason,omitempty"`
+	ErrorClass  ErrorClass `json:"errorClass,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	RemoteKey   string     `json:"remoteKey,omitempty"`
+	UploadTime  Duration   `json:"uploadMs,omitempty"`
+}
+
+// ActivityResult describes what happened to one activity. The activity is
+// synced once every destination has it.
+type ActivityResult struct {
+	ActivityID   int64      `json:"activityId"`
+	Name         string     `json:"name"`
+	Type         string     `json:"type"`
+	StartTime    time.Time  `json:"startTime"`
+	File         string     `json:"file,omitempty"` // local FIT file, for uploaded files
+	Status       Status     `json:"status"`
+	Reason       string     `json:"reason,omitempty"`
+	ErrorClass   ErrorClass `json:"errorClass,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	Bytes        int        `json:"bytes,omitempty"`
+	DownloadTime Duration   `json:"downloadMs,omitempty"`
+	UploadTime   Duration   `json:"uploadMs,omitempty"`
+
+	Destinations []DestinationResult `json:"destinations,omitempty"`
+}
+
+// Report is the machine-readable summary of a sync run
+type Report struct {
+	Profile    string           `json:"profile,omitempty"`
+	From       string           `json:"from,omitempty"`
+	To         string           `json:"to,omitempty"`
+	DryRun     bool             `json:"dryRun,omitempty"`
+	StartedAt  time.Time        `json:"startedAt"`
+	FinishedAt time.Time        `json:"finishedAt"`
+	Activities []ActivityResult `json:"activities"`
+	ErrorClass ErrorClass       `json:"errorClass,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Warnings   []string         `json:"warnings,omitempty"` // problems that did not fail the run
+	Synced     int              `json:"synced"`
+	Skipped    int              `json:"skipped"`
+	Failed     int              `json:"failed"`
+}
+
+// Duration is a time.Duration serialized as whole milliseconds
+type Duration time.Duration
+
+// MarshalJSON encodes the duration in milliseconds
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d", time.Duration(d).Milliseconds())), nil
+}
+
+// newReport starts a report for a date range
+func newReport(start, end time.Time, dryRun bool) *Report {
+	return &Report{
+		From:       start.Format("2006-01-02"),
+		To:         end.Format("2006-01-02"),
+		DryRun:     dryRun,
+		StartedAt:  time.Now().UTC(),
+		Activities: []ActivityResult{},
+	}
+}
+
+// FailedReport returns the report of a run that failed before any activity
+// was looked at, e.g. because a login was rejected
+func FailedReport(class ErrorClass, err error) *Report {
+	report := &Report{StartedAt: time.Now().UTC(), Activities: []ActivityResult{}}
+	report.fail(classify(class, err), err)
+	report.finish(nil)
+	return report
+}
+
+// fail records a run-level error
+func (r *Report) fail(class ErrorClass, err error) {
+	r.ErrorClass = class
+	r.Error = err.Error()
+}
+
+// finish adds the activity results and computes the totals
+func (r *Report) finish(results []ActivityResult) {
+	r.Activities = append(r.Activities, results...)
+	r.Synced, r.Skipped, r.Failed = 0, 0, 0
+	for _, result := range r.Activities {
+		switch result.Status {
+		case StatusSynced:
+			r.Synced++
+		case StatusSkipped:
+			r.Skipped++
+		case StatusFailed:
+			r.Failed++
+		}
+	}
+	r.FinishedAt = time.Now().UTC()
+}
+
+// HasAuthFailure reports whether the run or any activity failed because a
+// session or login was rejected
+func (r *Report) HasAuthFailure() bool {
+	if r.ErrorClass == ErrorAuth || r.ErrorClass == ErrorReauth {
+		return true
+	}
+	for _, result := range r.Activities {
+		if result.ErrorClass == ErrorAuth || result.ErrorClass == ErrorReauth {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFailures reports whether the run or any activity failed
+func (r *Report) HasFailures() bool {
+	return r.Error != "" || r.Failed > 0
+}
+
+// newResult creates a pending result for an activity
+func newResult(activity garmin.Activity) ActivityResult {
+	return ActivityResult{
+		ActivityID: activity.ActivityID,
+		Name:       activity.ActivityName,
+		Type:       activity.ActivityType,
+		StartTime:  activity.StartTime,
+	}
+}
+
+// authFailure is implemented by errors that know whether they were caused by
+// rejected credentials
+type authFailure interface {
+	AuthFailure() bool
+}
+
+// classify returns ErrorReauth or ErrorAuth for login and session errors, and
+// class otherwise
+func classify(class ErrorClass, err error) ErrorClass {
+	if errors.Is(err, garmin.ErrReauthRequired) {
+		return ErrorReauth
+	}
+	var auth authFailure
+	if errors.As(err, &auth) && auth.AuthFailure() {
+		return ErrorAuth
+	}
+	if errors.Is(err, ido.ErrSessionExpired) {
+		return ErrorAuth
+	}
+	return class
+}
@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+
+	"garmin-to-ido/internal/destination"
+	"garmin-to-ido/internal/ledger"
+)
+
+// RetryFailed replays the uploads in the ledger's failed queue from their
+// archived FIT files, without downloading anything from Garmin. If ids is
+// not empty, only those activities are retried.
+func (s *Syncer) RetryFailed(ids map[int64]bool, debug bool) (*Report, error) {
+	report := &Report{StartedAt: time.Now().UTC(), Activities: []ActivityResult{}}
+
+	if s.opts.Ledger == nil {
+		err := fmt.Errorf("no ledger configured")
+		report.fail(ErrorUpload, err)
+		report.finish(nil)
+		return report, err
+	}
+
+	var queue []ledger.Entry
+	for _, entry := range s.opts.Ledger.Failed() {
+		if len(ids) == 0 || ids[entry.ActivityID] {
+			queue = append(queue, entry)
+		}
+	}
+
+	if len(queue) == 0 {
+		fmt.Fprintf(s.out, "  No failed uploads to retry\n")
+		report.finish(nil)
+		return report, nil
+	}
+
+	fmt.Fprintf(s.out, "  Retrying %d failed upload(s)\n", len(queue))
+
+	results := make([]ActivityResult, 0, len(queue))
+	for i, entry := range queue {
+		fmt.Fprintf(s.out, "  [%d/%d] %s (activity %d → %s, %d previous attempt(s))\n",
+			i+1, len(queue), entry.ActivityName, entry.ActivityID, entry.Destination, entry.Attempts)
+
+		result := ActivityResult{
+			ActivityID: entry.ActivityID,
+			Name:       entry.ActivityName,
+			Type:       entry.ActivityType,
+			StartTime:  entry.StartTime,
+		}
+
+		dest, ok := s.destination(entry.Destination)
+		if !ok {
+			fmt.Fprintf(s.out, "    ✗ Destination %q is not configured, skipping\n", entry.Destination)
+			result.Status = StatusSkipped
+			result.Reason = "destination not configured"
+			results = append(results, result)
+			continue
+		}
+
+		if entry.FitPath == "" && entry.FitHash == "" {
+			fmt.Fprintf(s.out, "    ✗ No archived FIT file, it will be downloaded again on the next sync\n")
+			result.Status = StatusSkipped
+			result.Reason = "no archived FIT file"
+			results = append(results, result)
+			continue
+		}
+
+		fitData, err := s.readArchived(entry)
+		if err != nil {
+			fmt.Fprintf(s.out, "    ✗ Failed to read archived FIT file: %v\n", err)
+			result.Status = StatusFailed
+			result.ErrorClass = ErrorExtract
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Bytes = len(fitData)
+
+		upload := destination.Upload{
+			ActivityID:   entry.ActivityID,
+			ActivityName: entry.ActivityName,
+			ActivityType: entry.ActivityType,
+			StartTime:    entry.StartTime,
+			FitData:      fitData,
+			Output:       s.out,
+			Debug:        debug,
+		}
+
+		started := time.Now()
+		// The failed upload may have reached the destination after all
+		remoteKey, err := s.uploadRetrying(dest, upload, true, func(attempt int, delay time.Duration, err error) {
+			fmt.Fprintf(s.out, "    ↻ %v - retrying in %s (attempt %d)\n", err, delay, attempt)
+		})
+		result.UploadTime = Duration(time.Since(started))
+		if err != nil {
+			fmt.Fprintf(s.out, "    ✗ Failed to upload: %v\n", err)
+			result.Status = StatusFailed
+			result.ErrorClass = classify(ErrorUpload, err)
+			result.Error = err.Error()
+			result.Destinations = []DestinationResult{{
+				Destination: dest.Name(),
+				Status:      StatusFailed,
+				ErrorClass:  result.ErrorClass,
+				Error:       result.Error,
+				UploadTime:  result.UploadTime,
+			}}
+			results = append(results, result)
+			if err := s.opts.Ledger.MarkFailed(entry, err); err != nil {
+				fmt.Fprintf(s.out, "    ✗ Failed to update ledger: %v\n", err)
+			}
+			continue
+		}
+
+		entry.RemoteKey = remoteKey
+		if err := s.opts.Ledger.MarkUploaded(entry); err != nil {
+			fmt.Fprintf(s.out, "    ✗ Failed to update ledger: %v\n", err)
+		}
+		fmt.Fprintf(s.out, "    ✓ Synced successfully\n")
+		result.Status = StatusSynced
+		result.Destinations = []DestinationResult{{
+			Destination: dest.Name(),
+			Status:      StatusSynced,
+			RemoteKey:   remoteKey,
+			UploadTime:  result.UploadTime,
+		}}
+		results = append(results, result)
+	}
+
+	report.finish(results)
+	fmt.Fprintf(s.out, "  %d of %d failed upload(s) recovered\n", report.Synced, len(queue))
+	s.pruneArchive()
+	return report, nil
+}
@@ -1,131 +1,357 @@
 package sync
 
 import (
-	"archive/zip"
-	"bytes"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"slices"
 	"time"
 
+	"garmin-to-ido/internal/archive"
+	"garmin-to-ido/internal/destination"
+	"garmin-to-ido/internal/filter"
 	"garmin-to-ido/internal/garmin"
-	"garmin-to-ido/internal/ido"
+	"garmin-to-ido/internal/ledger"
+	"garmin-to-ido/internal/metrics"
+	"garmin-to-ido/internal/naming"
+	"garmin-to-ido/internal/retry"
 )
 
-// Syncer handles synchronization between Garmin and iDO
+// Options controls how the syncer behaves
+type Options struct {
+	// Ledger records uploaded activities so re-runs are idempotent
+	Ledger *ledger.Ledger
+	// Force lists activity IDs to re-upload even if the ledger has them
+	Force map[int64]bool
+	// DryRun only prints the sync plan; iDO is never contacted
+	DryRun bool
+
+	// DownloadConcurrency and UploadConcurrency bound the number of
+	// activities downloaded from Garmin and uploaded to iDO in parallel
+	DownloadConcurrency int
+	UploadConcurrency   int
+	// GarminRateLimit caps the requests per minute sent to Garmin and
+	// UploadRateLimit the uploads per minute sent to each destination.
+	// Zero means unlimited.
+	GarminRateLimit int
+	UploadRateLimit int
+
+	// Retry controls how transient download and upload failures are retried
+	Retry retry.Policy
+
+	// Sports is the whitelist of sports synced by SyncActivities. Empty
+	// means bike only.
+	Sports []garmin.Sport
+
+	// Location is the athlete's time zone. Activities belong to the date
+	// they started on in this zone. Defaults to the local time zone.
+	Location *time.Location
+
+	// Filter selects which activities are synced. Nil keeps them all.
+	Filter *filter.Filter
+
+	// Namer names the activities uploaded to destinations. Nil keeps the
+	// Garmin names.
+	Namer *naming.Namer
+
+	// Metrics records the sync metrics. Nil disables them.
+	Metrics *metrics.Recorder
+
+	// Archive keeps the downloaded files for later re-uploads. Nil disables it.
+	Archive *archive.Archive
+
+	// Output receives the human-readable progress. Defaults to os.Stdout.
+	Output io.Writer
+}
+
+// Syncer handles synchronization between Garmin and one or more destinations
 type Syncer struct {
 	garminClient garmin.GarminClient
-	idoClient    *ido.Client
+	destinations []destination.Destination
+	opts         Options
+	out          io.Writer
+
+	garminLimiter  *rateLimiter
+	uploadLimiters map[string]*rateLimiter
+
+	// remote holds, per destination, the activities it already had when the
+	// current run started
+	remote map[string][]destination.RemoteActivity
 }
 
-// NewSyncer creates a new syncer
-func NewSyncer(garminClient garmin.GarminClient, idoClient *ido.Client) *Syncer {
+// NewSyncer creates a new syncer uploading to the given destinations. In
+// dry-run mode, the destinations are only used for their name and are never
+// contacted, so they may be logged out.
+func NewSyncer(garminClient garmin.GarminClient, destinations []destination.Destination, opts Options) *Syncer {
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+
+	uploadLimiters := make(map[string]*rateLimiter)
+	for _, dest := range destinations {
+		uploadLimiters[dest.Name()] = newRateLimiter(opts.UploadRateLimit)
+	}
+
 	return &Syncer{
 		garminClient: garminClient,
-		idoClient:    idoClient,
+		destinations: destinations,
+		opts:         opts,
+		out:          opts.Output,
+
+		garminLimiter:  newRateLimiter(opts.GarminRateLimit),
+		uploadLimiters: uploadLimiters,
 	}
 }
 
-// SyncBikeActivities synchronizes bike activities for a specific date
-func (s *Syncer) SyncBikeActivities(date time.Time, debug bool) error {
-	// Get bike activities from Garmin
-	activities, err := s.garminClient.GetBikeActivities(date)
+// uploadName returns the name the activity is uploaded with. A template that
+// fails to render is reported and the Garmin name is used instead.
+func (s *Syncer) uploadName(activity garmin.Activity, out io.Writer) string {
+	name, err := s.opts.Namer.Name(activity)
 	if err != nil {
-		return fmt.Errorf("failed to get bike activities: %w", err)
+		fmt.Fprintf(out, "    ✗ %v, keeping the Garmin name\n", err)
 	}
+	return name
+}
 
-	if len(activities) == 0 {
-		fmt.Printf("  No bike activities found for %s\n", date.Format("2006-01-02"))
-		return nil
+// alreadyUploaded reports whether the ledger says the destination has the
+// activity, unless a re-upload is forced
+func (s *Syncer) alreadyUploaded(dest destination.Destination, activity garmin.Activity) bool {
+	if s.opts.Ledger == nil || s.opts.Force[activity.ActivityID] {
+		return false
 	}
+	return s.opts.Ledger.IsUploaded(dest.Name(), activity.ActivityID)
+}
 
-	fmt.Printf("  Found %d bike activity(ies)\n", len(activities))
-
-	// Upload each activity to iDO
-	for i, activity := range activities {
-		fmt.Printf("  [%d/%d] %s (%.2f km, %.0f min)\n",
-			i+1, len(activities),
-			activity.ActivityName,
-			activity.Distance/1000,
-			activity.Duration/60,
-		)
+// alreadyPresent looks for the activity among the ones the destination
+// already had, unless a re-upload is forced
+func (s *Syncer) alreadyPresent(dest destination.Destination, activity garmin.Activity) (destination.RemoteActivity, bool) {
+	if s.opts.Force[activity.ActivityID] {
+		return destination.RemoteActivity{}, false
+	}
+	return destination.FindMatch(s.remote[dest.Name()], destination.Upload{
+		StartTime: activity.StartTime,
+		Duration:  activity.Duration,
+	})
+}
 
-		// Download activity data (this is a ZIP file from Garmin)
-		zipData, err := s.garminClient.DownloadActivity(activity.ActivityID)
-		if err != nil {
-			fmt.Printf("    ✗ Failed to download: %v\n", err)
+// listRemote fetches the activities the destinations already have around
+// the synced range. A destination that cannot be listed only relies on the
+// ledger to avoid duplicates, and a warning for the report is returned.
+func (s *Syncer) listRemote(start, end time.Time) []string {
+	var warnings []string
+	s.remote = make(map[string][]destination.RemoteActivity)
+	for _, dest := range s.destinations {
+		lister, ok := dest.(destination.Lister)
+		if !ok {
 			continue
 		}
 
-		// Extract the FIT file from the ZIP
-		zipReader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+		var remote []destination.RemoteActivity
+		err := retry.Do(s.opts.Retry, func() error {
+			var err error
+			remote, err = lister.ListActivities(start.AddDate(0, 0, -1), end.AddDate(0, 0, 1))
+			return err
+		}, nil)
 		if err != nil {
-			fmt.Printf("    ✗ Failed to read ZIP: %v\n", err)
+			warning := fmt.Sprintf("Could not list %s activities, relying on the ledger: %v", destinationLabel(dest.Name()), err)
+			fmt.Fprintf(s.out, "  ✗ %s\n", warning)
+			warnings = append(warnings, warning)
 			continue
 		}
+		s.remote[dest.Name()] = remote
+	}
+	return warnings
+}
+
+// destinationLabel returns the display name of a destination
+func destinationLabel(name string) string {
+	if name == "ido" {
+		return "iDO"
+	}
+	return name
+}
 
-		var fitData []byte
-		var fitFilename string
-		for _, file := range zipReader.File {
-			if filepath.Ext(file.Name) == ".fit" {
-				fitFilename = file.Name
-				rc, err := file.Open()
-				if err != nil {
-					fmt.Printf("    ✗ Failed to open FIT file in ZIP: %v\n", err)
-					break
-				}
-				fitData, err = io.ReadAll(rc)
-				rc.Close()
-				if err != nil {
-					fmt.Printf("    ✗ Failed to read FIT file: %v\n", err)
-					break
-				}
-				break
-			}
+// destination returns the configured destination with the given name
+func (s *Syncer) destination(name string) (destination.Destination, bool) {
+	for _, dest := range s.destinations {
+		if dest.Name() == name {
+			return dest, true
 		}
+	}
+	return nil, false
+}
 
-		if len(fitData) == 0 {
-			fmt.Printf("    ✗ No FIT file found in ZIP\n")
-			continue
+// SyncActivities synchronizes the activities of the configured sports
+// between two dates (inclusive)
+func (s *Syncer) SyncActivities(start, end time.Time, debug bool) (*Report, error) {
+	return s.syncSports(start, end, s.opts.Sports, debug)
+}
+
+// SyncBikeActivities synchronizes bike activities for a specific date
+func (s *Syncer) SyncBikeActivities(date time.Time, debug bool) (*Report, error) {
+	return s.SyncBikeActivitiesRange(date, date, debug)
+}
+
+// SyncBikeActivitiesRange synchronizes bike activities between two dates
+// (inclusive), whatever sports are configured
+func (s *Syncer) SyncBikeActivitiesRange(start, end time.Time, debug bool) (*Report, error) {
+	return s.syncSports(start, end, []garmin.Sport{garmin.SportBike}, debug)
+}
+
+// syncSports synchronizes the activities of the given sports between two
+// dates (inclusive), fetching the whole range from Garmin in one call. The
+// report is returned even when the run fails.
+func (s *Syncer) syncSports(start, end time.Time, sports []garmin.Sport, debug bool) (*Report, error) {
+	report := newReport(start, end, s.opts.DryRun)
+	if len(sports) == 0 {
+		sports = []garmin.Sport{garmin.SportBike}
+	}
+
+	// Get all activities from Garmin and keep the whitelisted sports
+	allActivities, err := s.getActivities(start, end)
+	if err != nil {
+		err = fmt.Errorf("failed to get activities: %w", err)
+		report.fail(classify(ErrorList, err), err)
+		report.finish(nil)
+		return report, err
+	}
+
+	var sportActivities []garmin.Activity
+	for _, activity := range allActivities {
+		if slices.Contains(sports, activity.Sport()) {
+			sportActivities = append(sportActivities, activity)
 		}
+	}
 
-		fmt.Printf("    → Extracted FIT file: %s (%d bytes)\n", fitFilename, len(fitData))
+	if len(sportActivities) == 0 {
+		fmt.Fprintf(s.out, "  No %s activities found for %s\n", garmin.JoinSports(sports, "/"), formatRange(start, end))
+		report.finish(nil)
+		return report, nil
+	}
 
-		// Save both ZIP and FIT files to disk
-		fitDir := "downloaded_fits"
-		if err := os.MkdirAll(fitDir, 0755); err != nil {
-			fmt.Printf("    ✗ Failed to create directory: %v\n", err)
-			continue
+	fmt.Fprintf(s.out, "  Found %d %s activity(ies)\n", len(sportActivities), garmin.JoinSports(sports, "/"))
+	if !s.opts.DryRun {
+		for _, activity := range sportActivities {
+			s.opts.Metrics.Discovered(string(activity.Sport()))
 		}
+	}
+
+	activities, filtered := s.applyFilter(sportActivities)
+	if len(activities) == 0 {
+		report.finish(filtered)
+		return report, nil
+	}
+
+	if s.opts.DryRun {
+		report.finish(append(filtered, s.printPlan(activities)...))
+		return report, nil
+	}
+
+	report.Warnings = s.listRemote(start, end)
+	results := s.runPipeline(activities, debug)
+	s.recordMetrics(results)
+	report.finish(append(filtered, results...))
+	s.pruneArchive()
+	return report, nil
+}
 
-		// Create filename with timestamp and activity ID
-		timestamp := activity.StartTime.Format("20060102_150405")
+// pruneArchive applies the archive retention policy, keeping the FIT files
+// still needed to retry failed uploads
+func (s *Syncer) pruneArchive() {
+	if s.opts.Archive == nil {
+		return
+	}
 
-		// Save the original ZIP file
-		zipFilename := filepath.Join(fitDir, fmt.Sprintf("%s_%d_%s.zip", timestamp, activity.ActivityID, activity.ActivityType))
-		if err := os.WriteFile(zipFilename, zipData, 0644); err != nil {
-			fmt.Printf("    ✗ Failed to save ZIP file: %v\n", err)
-		} else {
-			fmt.Printf("    → Saved ZIP file: %s\n", zipFilename)
+	keep := make(map[string]bool)
+	if s.opts.Ledger != nil {
+		for _, entry := range s.opts.Ledger.Failed() {
+			keep[entry.FitHash] = true
 		}
+	}
+
+	removed, err := s.opts.Archive.Prune(keep)
+	if err != nil {
+		fmt.Fprintf(s.out, "  ✗ Failed to prune archive: %v\n", err)
+	}
+	if len(removed) > 0 {
+		fmt.Fprintf(s.out, "  → Removed %d archived activity(ies) past the retention policy\n", len(removed))
+	}
+}
 
-		// Save the extracted FIT file
-		fitFilePath := filepath.Join(fitDir, fmt.Sprintf("%s_%d_%s.fit", timestamp, activity.ActivityID, activity.ActivityType))
-		if err := os.WriteFile(fitFilePath, fitData, 0644); err != nil {
-			fmt.Printf("    ✗ Failed to save FIT file: %v\n", err)
-		} else {
-			fmt.Printf("    → Saved FIT file: %s\n", fitFilePath)
+// readArchived returns the FIT file archived for a ledger entry
+func (s *Syncer) readArchived(entry ledger.Entry) ([]byte, error) {
+	if s.opts.Archive != nil && entry.FitHash != "" {
+		if _, ok := s.opts.Archive.Get(entry.FitHash); ok {
+			return s.opts.Archive.Read(entry.FitHash)
 		}
+	}
+	return os.ReadFile(entry.FitPath)
+}
 
-		// Upload the extracted FIT data to iDO (not the ZIP)
-		if err := s.idoClient.UploadActivity(fitData, activity.ActivityName, activity.ActivityType, activity.StartTime, debug); err != nil {
-			fmt.Printf("    ✗ Failed to upload: %v\n", err)
+// applyFilter splits the activities into the ones to sync and skipped
+// results for the ones rejected by the configured filter
+func (s *Syncer) applyFilter(activities []garmin.Activity) ([]garmin.Activity, []ActivityResult) {
+	var selected []garmin.Activity
+	var skipped []ActivityResult
+	for _, activity := range activities {
+		ok, reason := s.opts.Filter.Match(activity)
+		if ok {
+			selected = append(selected, activity)
 			continue
 		}
 
-		fmt.Printf("    ✓ Synced successfully\n")
+		fmt.Fprintf(s.out, "  - %s (activity %d) filtered out: %s\n", activity.ActivityName, activity.ActivityID, reason)
+		result := newResult(activity)
+		result.Status = StatusSkipped
+		result.Reason = "filtered: " + reason
+		skipped = append(skipped, result)
+	}
+	return selected, skipped
+}
+
+// recordMetrics counts the synced and failed activities
+func (s *Syncer) recordMetrics(results []ActivityResult) {
+	for _, result := range results {
+		sport := string(garmin.SportForType(result.Type))
+		switch result.Status {
+		case StatusSynced:
+			s.opts.Metrics.Synced(sport)
+		case StatusFailed:
+			s.opts.Metrics.Failed(sport, string(result.ErrorClass))
+		}
+	}
+}
+
+// getActivities returns the Garmin activities that started between two dates
+// (inclusive) in the athlete's time zone. Garmin selects activities by the
+// date they were recorded on, so a day more is asked on each side.
+func (s *Syncer) getActivities(start, end time.Time) ([]garmin.Activity, error) {
+	loc := s.opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	s.garminLimiter.Wait()
+	listed, err := s.garminClient.GetActivitiesInRange(start.AddDate(0, 0, -1), end.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	first, last := start.Format("2006-01-02"), end.Format("2006-01-02")
+	var activities []garmin.Activity
+	for _, activity := range listed {
+		activity = activity.In(loc)
+		if day := activity.StartTime.Format("2006-01-02"); day >= first && day <= last {
+			activities = append(activities, activity)
+		}
 	}
+	return activities, nil
+}
 
-	return nil
+// formatRange formats a date range for display
+func formatRange(start, end time.Time) string {
+	if start.Format("2006-01-02") == end.Format("2006-01-02") {
+		return start.Format("2006-01-02")
+	}
+	return start.Format("2006-01-02") + " to " + end.Format("2006-01-02")
 }
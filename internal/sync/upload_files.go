@@ -0,0 +1,225 @@
+package sync
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"garmin-to-ido/internal/archive"
+	"garmin-to-ido/internal/fit"
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/ledger"
+)
+
+// UploadFiles uploads local FIT files, or the FIT files found in directories,
+// to every destination without contacting Garmin. Start time and sport are
+// read from the files. Files the ledger already has for a destination are
+// skipped unless force is set.
+func (s *Syncer) UploadFiles(paths []string, force, debug bool) (*Report, error) {
+	report := &Report{StartedAt: time.Now().UTC(), Activities: []ActivityResult{}}
+
+	files, err := findFitFiles(paths)
+	if err != nil {
+		report.fail(ErrorList, err)
+		report.finish(nil)
+		return report, err
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(s.out, "  No FIT files found\n")
+		report.finish(nil)
+		return report, nil
+	}
+
+	fmt.Fprintf(s.out, "  Found %d FIT file(s)\n", len(files))
+
+	// Read every file first, so the destinations are listed only once
+	results := make([]ActivityResult, 0, len(files))
+	var jobs []*job
+	var first, last time.Time
+	for i, path := range files {
+		j, err := readFitFile(path, s.opts.Location)
+		if err != nil {
+			fmt.Fprintf(s.out, "  [%d/%d] %s\n    ✗ %v\n", i+1, len(files), path, err)
+			result := ActivityResult{Name: filepath.Base(path), File: path, Status: StatusFailed, ErrorClass: ErrorExtract, Error: err.Error()}
+			results = append(results, result)
+			continue
+		}
+		j.index = i
+		jobs = append(jobs, j)
+
+		if first.IsZero() || j.activity.StartTime.Before(first) {
+			first = j.activity.StartTime
+		}
+		if last.IsZero() || j.activity.StartTime.After(last) {
+			last = j.activity.StartTime
+		}
+	}
+
+	if len(jobs) > 0 {
+		report.Warnings = s.listRemote(first, last)
+	}
+
+	for _, j := range jobs {
+		activity := j.activity
+		fmt.Fprintf(&j.out, "  [%d/%d] %s (%s, %s, %.2f km, %.0f min)\n",
+			j.index+1, len(files), j.result.File,
+			activity.ActivityType,
+			activity.StartTime.Format("2006-01-02 15:04"),
+			activity.Distance/1000,
+			activity.Duration/60,
+		)
+		j.name = s.uploadName(activity, &j.out)
+
+		for _, dest := range s.destinations {
+			if !force && s.opts.Ledger != nil {
+				if entry, ok := s.opts.Ledger.FindByHash(dest.Name(), j.fitHash); ok && entry.Status == ledger.StatusUploaded {
+					fmt.Fprintf(&j.out, "    ✓ Already synced to %s (activity %d), skipping\n", destinationLabel(dest.Name()), entry.ActivityID)
+					j.result.Destinations = append(j.result.Destinations, DestinationResult{
+						Destination: dest.Name(),
+						Status:      StatusSkipped,
+						Reason:      "already synced",
+					})
+					continue
+				}
+			}
+			if !force {
+				if remote, ok := s.alreadyPresent(dest, activity); ok {
+					s.recordPresent(j, dest, remote)
+					continue
+				}
+			}
+			j.targets = append(j.targets, dest)
+		}
+
+		if len(j.targets) == 0 {
+			j.result.Status = StatusSkipped
+			j.result.Reason = skipReason(j.result.Destinations)
+		} else {
+			s.archiveLocal(j)
+			s.upload(j, debug)
+		}
+
+		s.out.Write(j.out.Bytes())
+		results = append(results, j.result)
+	}
+
+	report.finish(results)
+	fmt.Fprintf(s.out, "  %d of %d file(s) uploaded\n", report.Synced, len(files))
+	s.pruneArchive()
+	return report, nil
+}
+
+// readFitFile reads a local FIT file into a job, with the start time in the
+// athlete's time zone loc. Local files have no Garmin activity ID, so one is
+// derived from the content hash.
+func readFitFile(path string, loc *time.Location) (*job, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	info, err := fit.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FIT file: %w", err)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+
+	fitHash := archive.Hash(data)
+	activity := garmin.Activity{
+		ActivityID:     localActivityID(fitHash),
+		ActivityName:   strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		ActivityType:   info.TypeKey(),
+		StartTimeGMT:   info.StartTime.UTC(),
+		StartTimeLocal: info.LocalStartTime(loc),
+		StartTime:      info.StartTime.In(loc),
+		Distance:       info.Distance,
+		Duration:       info.Duration,
+	}
+
+	j := &job{
+		activity: activity,
+		result:   newResult(activity),
+		fitData:  data,
+		fitHash:  fitHash,
+		fitPath:  absPath,
+	}
+	j.result.File = path
+	j.result.Bytes = len(data)
+	return j, nil
+}
+
+// archiveLocal copies a local file into the archive, if enabled, so it can
+// still be replayed by retry-failed once the original is gone
+func (s *Syncer) archiveLocal(j *job) {
+	if s.opts.Archive == nil {
+		return
+	}
+
+	record, _, err := s.opts.Archive.Store(archive.Activity{
+		ActivityID:   j.activity.ActivityID,
+		ActivityName: j.activity.ActivityName,
+		ActivityType: j.activity.ActivityType,
+		StartTime:    j.activity.StartTime,
+		FitData:      j.fitData,
+	})
+	if err != nil {
+		fmt.Fprintf(&j.out, "    ✗ Failed to archive FIT file: %v\n", err)
+		return
+	}
+	j.fitPath = s.opts.Archive.Path(record.FitFile)
+}
+
+// findFitFiles expands the given paths into a list of FIT files, walking
+// directories recursively
+func findFitFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && strings.EqualFold(filepath.Ext(file), ".fit") {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", path, err)
+		}
+	}
+	return files, nil
+}
+
+// localActivityID derives the ledger ID of a local FIT file from its content
+// hash. IDs are negative so they never collide with Garmin activity IDs.
+func localActivityID(fitHash string) int64 {
+	if len(fitHash) < 15 {
+		return -1
+	}
+	id, err := strconv.ParseInt(fitHash[:15], 16, 64)
+	if err != nil {
+		return -1
+	}
+	return -id - 1
+}
@@ -4,90 +4,502 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+	// Embedded zone database, so ATHLETE_TIMEZONE works on hosts without one
+	_ "time/tzdata"
 
+	"garmin-to-ido/internal/archive"
 	"garmin-to-ido/internal/config"
+	"garmin-to-ido/internal/destination"
+	"garmin-to-ido/internal/filter"
 	"garmin-to-ido/internal/garmin"
 	"garmin-to-ido/internal/ido"
+	"garmin-to-ido/internal/ledger"
+	"garmin-to-ido/internal/naming"
+	"garmin-to-ido/internal/notify"
+	"garmin-to-ido/internal/retry"
 	"garmin-to-ido/internal/sync"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			os.Exit(runServe(os.Args[2:]))
+		case "retry-failed":
+			os.Exit(runRetryFailed(os.Args[2:]))
+		case "upload":
+			os.Exit(runUpload(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "garmin-login":
+			os.Exit(runGarminLogin(os.Args[2:]))
+		}
+	}
+	os.Exit(runSync(os.Args[1:]))
+}
+
+// runSync performs a one-shot synchronization of the selected profiles and
+// returns the exit code
+func runSync(args []string) int {
 	// Parse command line flags
-	var dateFlag, configPath string
-	var debug bool
-	flag.StringVar(&dateFlag, "d", "", "Specific date to sync (format: YYYY-MM-DD). If not provided, syncs today and yesterday")
-	flag.StringVar(&dateFlag, "date", "", "Specific date to sync (format: YYYY-MM-DD). If not provided, syncs today and yesterday")
-	flag.StringVar(&configPath, "c", ".env", "Path to configuration file")
-	flag.StringVar(&configPath, "config", ".env", "Path to configuration file")
-	flag.BoolVar(&debug, "debug", false, "Log request/response details for debugging purposes")
-	flag.Parse()
-
-	// Load configuration
-	cfg, err := config.Load(configPath)
+	var dateFlag, fromFlag, toFlag, sinceFlag, configPath, profile, forceFlag, reportFormat string
+	var debug, dryRun, allProfiles bool
+	flags := flag.NewFlagSet("garmin-to-ido", flag.ContinueOnError)
+	flags.StringVar(&dateFlag, "d", "", "Specific date to sync (format: YYYY-MM-DD). If not provided, syncs today")
+	flags.StringVar(&dateFlag, "date", "", "Specific date to sync (format: YYYY-MM-DD). If not provided, syncs today")
+	flags.StringVar(&fromFlag, "from", "", "First date of the range to sync (format: YYYY-MM-DD)")
+	flags.StringVar(&toFlag, "to", "", "Last date of the range to sync (format: YYYY-MM-DD). Defaults to today")
+	flags.StringVar(&sinceFlag, "since", "", "Sync a window ending today, e.g. 7d, 2w or 36h")
+	flags.StringVar(&configPath, "c", ".env", "Path to configuration file")
+	flags.StringVar(&configPath, "config", ".env", "Path to configuration file")
+	flags.StringVar(&profile, "profile", "", "Profile of the configuration file to sync")
+	flags.BoolVar(&allProfiles, "all-profiles", false, "Sync every profile of the configuration file, one after the other")
+	flags.BoolVar(&debug, "debug", false, "Log request/response details for debugging purposes")
+	flags.BoolVar(&dryRun, "dry-run", false, "Print the sync plan without downloading or uploading anything (iDO is not contacted)")
+	flags.StringVar(&forceFlag, "force", "", "Comma-separated Garmin activity IDs to re-upload even if already synced")
+	flags.StringVar(&reportFormat, "report", "text", "Report format: text, or json to print a machine-readable report on stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage:\n  garmin-to-ido [flags]                 one-shot sync\n  garmin-to-ido serve [flags]           run as a daemon\n  garmin-to-ido retry-failed [flags]    replay failed uploads from archived FIT files\n  garmin-to-ido upload [flags] <path>   upload local FIT files\n  garmin-to-ido export [flags]          download activities from Garmin into a folder\n  garmin-to-ido garmin-login [flags]    log into Garmin interactively (MFA) and save the session\n\nFlags:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(flags.Output(), "\nExit codes: 0 all synced, 1 partial failure, 2 auth failure, 3 config error\n")
+	}
+	parseFlags(flags, args)
+	setReportFormat(reportFormat)
+
+	forceIDs, err := parseActivityIDs(forceFlag)
+	if err != nil {
+		fatal(exitConfigError, "Invalid -force value: %v", err)
+	}
+
+	cfgs := loadConfigs(configPath, profile, allProfiles)
+
+	// Profiles are synced one after the other; the worst outcome sets the exit code
+	code := exitOK
+	for _, cfg := range cfgs {
+		// Today is the athlete's today, which may differ between profiles
+		startDate, endDate, err := resolveDateRange(dateFlag, fromFlag, toFlag, sinceFlag, time.Now().In(cfg.Timezone))
+		if err != nil {
+			fatal(exitConfigError, "Invalid date selection: %v", err)
+		}
+
+		printProfile(cfg)
+		report, profileCode := syncProfile(cfg, startDate, endDate, forceIDs, dryRun, debug)
+		report.Profile = cfg.Profile
+		writeReport(reportFormat, report)
+		openNotifier(cfg).Send(report)
+		code = max(code, profileCode)
+	}
+	return code
+}
+
+// syncProfile syncs the activities of one profile and returns the report and
+// the exit code. Configuration and login failures end the profile's run, not
+// the process, so the other profiles are still synced.
+func syncProfile(cfg *config.Config, startDate, endDate time.Time, forceIDs map[int64]bool, dryRun, debug bool) (*sync.Report, int) {
+	fmt.Fprintf(console, "Synchronizing %s activities from %s to %s\n", garmin.JoinSports(cfg.Sports, ", "), startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
+
+	// The configuration is checked before any session is opened
+	syncLedger, err := ledger.Open(cfg.LedgerPath)
+	if err != nil {
+		err = fmt.Errorf("failed to open sync ledger: %w", err)
+		log.Printf("%v", err)
+		return sync.FailedReport(sync.ErrorConfig, err), exitConfigError
+	}
+	opts, err := syncOptions(cfg, syncLedger)
+	if err != nil {
+		log.Printf("%v", err)
+		return sync.FailedReport(sync.ErrorConfig, err), exitConfigError
+	}
+	opts.Force = forceIDs
+	opts.DryRun = dryRun
+	opts.Output = console
+
+	destinations, err := openDestinations(cfg)
+	if err != nil {
+		log.Printf("%v", err)
+		return sync.FailedReport(sync.ErrorConfig, err), exitConfigError
+	}
+	defer closeDestinations(destinations)
+
+	garminClient, err := loginGarmin(cfg, nil)
+	if err != nil {
+		log.Printf("Failed to initialize Garmin client: %v", err)
+		return sync.FailedReport(sync.ErrorAuth, err), garminLoginExitCode(err)
+	}
+	defer garminClient.Logout()
+
+	// In dry-run mode destinations are never logged into, so no browser is started
+	if !dryRun {
+		if err := loginDestinations(destinations); err != nil {
+			log.Printf("%v", err)
+			return sync.FailedReport(sync.ErrorAuth, err), exitAuthFailure
+		}
+	}
+
+	// Sync activities
+	syncer := sync.NewSyncer(garminClient, destinations, opts)
+	fmt.Fprintln(console, "\nSyncing activities...")
+	report, err := syncer.SyncActivities(startDate, endDate, debug)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		log.Printf("Error syncing activities: %v", err)
 	}
 
-	// Validate configuration
-	if err := cfg.Validate(); err != nil {
-		log.Fatalf("Invalid configuration: %v", err)
+	if dryRun {
+		fmt.Fprintln(console, "\n✓ Dry run completed, nothing was uploaded")
+		return report, exitCode(report)
+	}
+	fmt.Fprintln(console, "\n✓ Synchronization completed")
+	printSummary(report)
+	return report, exitCode(report)
+}
+
+// loadConfigs loads and validates the configuration of the selected
+// profiles: the given one, all of them, or the whole file if it defines no
+// profiles
+func loadConfigs(path, profile string, all bool) []*config.Config {
+	if profile != "" && all {
+		fatal(exitConfigError, "-profile and -all-profiles cannot be combined")
+	}
+
+	profiles, err := config.Profiles(path)
+	if err != nil {
+		fatal(exitConfigError, "Failed to load configuration: %v", err)
+	}
+
+	var names []string
+	switch {
+	case all:
+		if len(profiles) == 0 {
+			fatal(exitConfigError, "-all-profiles: the configuration file defines no profile")
+		}
+		names = profiles
+	case profile != "":
+		names = []string{profile}
+	case len(profiles) > 0:
+		fatal(exitConfigError, "The configuration file defines profiles (%s), select one with -profile or use -all-profiles", strings.Join(profiles, ", "))
+	default:
+		names = []string{""}
 	}
 
-	// Determine dates to sync
-	var datesToSync []time.Time
-	if dateFlag != "" {
-		date, err := time.Parse("2006-01-02", dateFlag)
+	cfgs := make([]*config.Config, 0, len(names))
+	for _, name := range names {
+		cfg, err := config.LoadProfile(path, name)
 		if err != nil {
-			log.Fatalf("Invalid date format. Use YYYY-MM-DD: %v", err)
+			fatal(exitConfigError, "Failed to load configuration: %v", err)
 		}
-		datesToSync = []time.Time{date}
-	} else {
-		// Default: today and yesterday
-		now := time.Now()
-		datesToSync = []time.Time{
-			now,
+		if err := cfg.Validate(); err != nil {
+			if name != "" {
+				fatal(exitConfigError, "Invalid configuration of profile %s: %v", name, err)
+			}
+			fatal(exitConfigError, "Invalid configuration: %v", err)
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs
+}
+
+// loadGarminConfig loads the configuration of the selected profile for the
+// commands that only use Garmin. Like loadConfigs, it needs -profile if the
+// file defines profiles.
+func loadGarminConfig(path, profile string) *config.Config {
+	if profile == "" {
+		profiles, err := config.Profiles(path)
+		if err != nil {
+			fatal(exitConfigError, "Failed to load configuration: %v", err)
+		}
+		if len(profiles) > 0 {
+			fatal(exitConfigError, "The configuration file defines profiles (%s), select one with -profile", strings.Join(profiles, ", "))
 		}
 	}
 
-	fmt.Printf("Synchronizing bike activities for dates: ")
-	for i, date := range datesToSync {
-		if i > 0 {
-			fmt.Print(", ")
+	cfg, err := config.LoadProfile(path, profile)
+	if err != nil {
+		fatal(exitConfigError, "Failed to load configuration: %v", err)
+	}
+	if err := cfg.ValidateGarmin(); err != nil {
+		if profile != "" {
+			fatal(exitConfigError, "Invalid configuration of profile %s: %v", profile, err)
 		}
-		fmt.Print(date.Format("2006-01-02"))
+		fatal(exitConfigError, "Invalid configuration: %v", err)
+	}
+	return cfg
+}
+
+// loadConfig loads and validates the configuration of a single profile
+func loadConfig(path, profile string) *config.Config {
+	return loadConfigs(path, profile, false)[0]
+}
+
+// printProfile announces the profile about to run, if any
+func printProfile(cfg *config.Config) {
+	if cfg.Profile != "" {
+		fmt.Fprintf(console, "\n=== Profile %s ===\n", cfg.Profile)
+	}
+}
+
+// syncOptions builds the syncer options shared by all commands
+func syncOptions(cfg *config.Config, syncLedger *ledger.Ledger) (sync.Options, error) {
+	activityFilter, err := filter.New(cfg.Filter)
+	if err != nil {
+		return sync.Options{}, fmt.Errorf("invalid activity filter: %w", err)
+	}
+	namer, err := naming.New(cfg.NameTemplate, cfg.SportNameTemplates)
+	if err != nil {
+		return sync.Options{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	activityArchive, err := openArchive(cfg)
+	if err != nil {
+		return sync.Options{}, err
+	}
+
+	return sync.Options{
+		Ledger:              syncLedger,
+		Archive:             activityArchive,
+		Sports:              cfg.Sports,
+		Location:            cfg.Timezone,
+		Filter:              activityFilter,
+		Namer:               namer,
+		DownloadConcurrency: cfg.DownloadConcurrency,
+		UploadConcurrency:   cfg.UploadConcurrency,
+		GarminRateLimit:     cfg.GarminRateLimit,
+		UploadRateLimit:     cfg.UploadRateLimit,
+		Retry:               retryPolicy(cfg),
+	}, nil
+}
+
+// openNotifier builds the configured notifiers, or returns nil if there are
+// none. loadConfigs has validated the settings before any profile ran.
+func openNotifier(cfg *config.Config) *notify.Dispatcher {
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		fatal(exitConfigError, "Invalid notification settings: %v", err)
 	}
-	fmt.Println()
+	return notifier
+}
 
-	// Initialize Garmin client (using Python wrapper)
-	garminClient := garmin.NewPythonClient(cfg.GarminUsername, cfg.GarminPassword)
+// retryPolicy returns the configured retry policy for transient failures
+func retryPolicy(cfg *config.Config) retry.Policy {
+	return retry.Policy{
+		MaxAttempts:  cfg.RetryMaxAttempts,
+		InitialDelay: cfg.RetryInitialDelay,
+		MaxDelay:     cfg.RetryMaxDelay,
+	}
+}
+
+// openArchive opens the archive of downloaded files, or returns nil if it
+// is disabled
+func openArchive(cfg *config.Config) (*archive.Archive, error) {
+	if !cfg.ArchiveEnabled {
+		return nil, nil
+	}
+
+	activityArchive, err := archive.Open(archive.Options{
+		Dir:          cfg.ArchiveDir,
+		NameTemplate: cfg.ArchiveNameTemplate,
+		MaxAge:       cfg.ArchiveMaxAge,
+		MaxCount:     cfg.ArchiveMaxCount,
+		MaxSize:      cfg.ArchiveMaxSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	return activityArchive, nil
+}
+
+// loginGarmin initializes the Garmin client selected by GARMIN_CLIENT, with
+// the session saved in GARMIN_TOKEN_DIR if any. promptMFA is only set for
+// interactive logins.
+func loginGarmin(cfg *config.Config, promptMFA garmin.MFAPrompt) (garmin.GarminClient, error) {
+	garminClient, err := newGarminClient(cfg, promptMFA)
+	if err != nil {
+		return nil, err
+	}
 	if err := garminClient.Login(); err != nil {
-		log.Fatalf("Failed to initialize Garmin client: %v", err)
+		// Removes what a failed login leaves behind, e.g. the Python script
+		garminClient.Logout()
+		return nil, err
 	}
-	defer garminClient.Logout()
-	fmt.Println("✓ Initialized Garmin Connect client")
+	fmt.Fprintln(console, "✓ Initialized Garmin Connect client")
+	return garminClient, nil
+}
 
-	// Initialize iDO client
-	idoClient, err := ido.NewClient(cfg.IdoUsername, cfg.IdoPassword)
+// newGarminClient creates the Garmin client selected by GARMIN_CLIENT, not
+// logged in yet. An interactive login (with promptMFA) does not reuse the
+// saved session, which may have been revoked, and replaces it on success.
+func newGarminClient(cfg *config.Config, promptMFA garmin.MFAPrompt) (garmin.GarminClient, error) {
+	var store *garmin.TokenStore
+	if cfg.GarminTokenDir != "" {
+		var err error
+		if store, err = garmin.NewTokenStore(cfg.GarminTokenDir); err != nil {
+			return nil, err
+		}
+	}
+
+	var garminClient garmin.GarminClient
+	switch cfg.GarminClient {
+	case "native":
+		client := garmin.NewNativeClient(cfg.GarminUsername, cfg.GarminPassword)
+		if cfg.GarminConsumerKey != "" {
+			client.SetConsumer(cfg.GarminConsumerKey, cfg.GarminConsumerSecret)
+		}
+		if store != nil {
+			client.SetTokenStore(store)
+		}
+		client.SetMFAPrompt(promptMFA)
+		client.SetFreshLogin(promptMFA != nil)
+		garminClient = client
+	default:
+		client := garmin.NewPythonClient(cfg.GarminUsername, cfg.GarminPassword)
+		if store != nil {
+			client.SetTokenStore(store)
+		}
+		client.SetMFAPrompt(promptMFA)
+		client.SetFreshLogin(promptMFA != nil)
+		garminClient = client
+	}
+	return garminClient, nil
+}
+
+// mustLoginGarmin initializes the Garmin client, exiting on failure
+func mustLoginGarmin(cfg *config.Config) garmin.GarminClient {
+	garminClient, err := loginGarmin(cfg, nil)
 	if err != nil {
-		log.Fatalf("Failed to initialize iDO client: %v", err)
+		fatal(garminLoginExitCode(err), "Failed to initialize Garmin client: %v", err)
 	}
-	defer idoClient.Close()
+	return garminClient
+}
 
-	if err := idoClient.Login(); err != nil {
-		log.Fatalf("Failed to login to iDO: %v", err)
+// openDestinations creates the configured destinations without logging in
+func openDestinations(cfg *config.Config) ([]destination.Destination, error) {
+	var destinations []destination.Destination
+	for _, name := range cfg.Destinations {
+		switch name {
+		case "ido":
+			idoClient, err := ido.NewClient(cfg.IdoUsername, cfg.IdoPassword)
+			if err != nil {
+				closeDestinations(destinations)
+				return nil, fmt.Errorf("failed to initialize iDO client: %w", err)
+			}
+			idoClient.SetLocation(cfg.Timezone)
+			destinations = append(destinations, idoClient)
+		case "folder":
+			destinations = append(destinations, destination.NewFolder(cfg.FolderDestinationDir))
+		}
 	}
-	fmt.Println("✓ Logged in to iDO Sport")
+	return destinations, nil
+}
 
-	// Sync activities
-	syncer := sync.NewSyncer(garminClient, idoClient)
-	for _, date := range datesToSync {
-		fmt.Printf("\nSyncing activities for %s...\n", date.Format("2006-01-02"))
-		if err := syncer.SyncBikeActivities(date, debug); err != nil {
-			log.Printf("Error syncing activities for %s: %v", date.Format("2006-01-02"), err)
+// loginDestinations logs into every destination
+func loginDestinations(destinations []destination.Destination) error {
+	for _, dest := range destinations {
+		if err := dest.Login(); err != nil {
+			return fmt.Errorf("failed to login to %s: %w", dest.Name(), err)
+		}
+		fmt.Fprintf(console, "✓ Logged in to %s\n", dest.Name())
+	}
+	return nil
+}
+
+// closeDestinations releases the destinations' resources (e.g. the browser)
+func closeDestinations(destinations []destination.Destination) {
+	for _, dest := range destinations {
+		dest.Close()
+	}
+}
+
+// parseActivityIDs parses a comma-separated list of Garmin activity IDs
+func parseActivityIDs(value string) (map[int64]bool, error) {
+	ids := make(map[int64]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
 			continue
 		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid activity ID %q", part)
+		}
+		ids[id] = true
 	}
+	return ids, nil
+}
+
+// resolveDateRange turns the date selection flags into an inclusive range of
+// dates. Without any flag, only today is synced. Today is taken from now, so
+// now should be in the athlete's time zone.
+func resolveDateRange(date, from, to, since string, now time.Time) (time.Time, time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
 
-	fmt.Println("\n✓ Synchronization completed")
+	if date != "" {
+		if from != "" || to != "" || since != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("-date cannot be combined with -from, -to or -since")
+		}
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid date format, use YYYY-MM-DD: %w", err)
+		}
+		return day, day, nil
+	}
+
+	if since != "" && from != "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("-since cannot be combined with -from")
+	}
+
+	end := today
+	if to != "" {
+		day, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid -to date, use YYYY-MM-DD: %w", err)
+		}
+		end = day
+	}
+
+	start := end
+	switch {
+	case from != "":
+		day, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid -from date, use YYYY-MM-DD: %w", err)
+		}
+		start = day
+	case since != "":
+		window, err := parseWindow(since)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		sinceTime := now.Add(-window)
+		start = time.Date(sinceTime.Year(), sinceTime.Month(), sinceTime.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end date %s is before start date %s", end.Format("2006-01-02"), start.Format("2006-01-02"))
+	}
+
+	return start, end, nil
+}
+
+// parseWindow parses a relative window such as "7d", "2w" or any Go duration
+func parseWindow(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if n := len(value); n > 1 && (value[n-1] == 'd' || value[n-1] == 'w') {
+		count, err := strconv.Atoi(value[:n-1])
+		if err != nil || count < 0 {
+			return 0, fmt.Errorf("invalid -since value %q", value)
+		}
+		days := count
+		if value[n-1] == 'w' {
+			days = count * 7
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		return 0, fmt.Errorf("invalid -since value %q (use e.g. 7d, 2w or 36h)", value)
+	}
+	return window, nil
 }
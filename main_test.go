@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveDateRange(t *testing.T) {
+	paris := time.FixedZone("CET", 3600)
+	// Just after midnight in Paris, still the day before in UTC
+	now := time.Date(2025, 1, 15, 0, 30, 0, 0, paris)
+
+	for _, test := range []struct {
+		name                  string
+		date, from, to, since string
+		wantStart, wantEnd    string
+		wantErr               bool
+	}{
+		{name: "today by default", wantStart: "2025-01-15", wantEnd: "2025-01-15"},
+		{name: "date", date: "2024-12-31", wantStart: "2024-12-31", wantEnd: "2024-12-31"},
+		{name: "from", from: "2025-01-10", wantStart: "2025-01-10", wantEnd: "2025-01-15"},
+		{name: "from and to", from: "2025-01-01", to: "2025-01-07", wantStart: "2025-01-01", wantEnd: "2025-01-07"},
+		{name: "single day range", from: "2025-01-07", to: "2025-01-07", wantStart: "2025-01-07", wantEnd: "2025-01-07"},
+		{name: "to only", to: "2025-01-07", wantStart: "2025-01-07", wantEnd: "2025-01-07"},
+		{name: "since days", since: "2d", wantStart: "2025-01-13", wantEnd: "2025-01-15"},
+		{name: "since weeks", since: "1w", wantStart: "2025-01-08", wantEnd: "2025-01-15"},
+		{name: "since duration", since: "1h", wantStart: "2025-01-14", wantEnd: "2025-01-15"},
+		{name: "since zero", since: "0d", wantStart: "2025-01-15", wantEnd: "2025-01-15"},
+		{name: "since and to", since: "2d", to: "2025-01-15", wantStart: "2025-01-13", wantEnd: "2025-01-15"},
+		{name: "date and from", date: "2025-01-01", from: "2025-01-01", wantErr: true},
+		{name: "date and since", date: "2025-01-01", since: "2d", wantErr: true},
+		{name: "since and from", since: "2d", from: "2025-01-01", wantErr: true},
+		{name: "end before start", from: "2025-01-10", to: "2025-01-01", wantErr: true},
+		{name: "since after to", since: "1d", to: "2025-01-01", wantErr: true},
+		{name: "invalid date", date: "15/01/2025", wantErr: true},
+		{name: "invalid from", from: "2025-13-01", wantErr: true},
+		{name: "invalid to", to: "tomorrow", wantErr: true},
+		{name: "invalid since", since: "two days", wantErr: true},
+	} {
+		start, end, err := resolveDateRange(test.date, test.from, test.to, test.since, now)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %s to %s, want an error", test.name, start.Format("2006-01-02"), end.Format("2006-01-02"))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := start.Format("2006-01-02"); got != test.wantStart {
+			t.Errorf("%s: start = %s, want %s", test.name, got, test.wantStart)
+		}
+		if got := end.Format("2006-01-02"); got != test.wantEnd {
+			t.Errorf("%s: end = %s, want %s", test.name, got, test.wantEnd)
+		}
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	for _, test := range []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "7d", want: 7 * 24 * time.Hour},
+		{value: " 2w ", want: 14 * 24 * time.Hour},
+		{value: "0d", want: 0},
+		{value: "36h", want: 36 * time.Hour},
+		{value: "90m", want: 90 * time.Minute},
+		{value: "-1d", wantErr: true},
+		{value: "-2h", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "1.5d", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "week", wantErr: true},
+	} {
+		got, err := parseWindow(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseWindow(%q) = %s, want an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseWindow(%q) = %s, %v, want %s", test.value, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/sync"
+)
+
+// Exit codes, so monitoring can tell apart the ways a run can end
+const (
+	exitOK             = 0 // every activity synced (or nothing to do)
+	exitPartialFailure = 1 // at least one activity or the run itself failed
+	exitAuthFailure    = 2 // Garmin or iDO rejected the login or session
+	exitConfigError    = 3 // invalid configuration or command line
+)
+
+// console receives human-readable progress. It is switched to stderr when
+// the report is written as JSON, so stdout only carries the report.
+var console io.Writer = os.Stdout
+
+// fatal logs the message and exits with the given code. os.Exit skips
+// deferred calls, so it is only used before any session, browser or worker
+// is started; after that, the run functions return their exit code.
+func fatal(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// parseFlags parses a command's flags, exiting with exitConfigError on
+// invalid usage
+func parseFlags(flags *flag.FlagSet, args []string) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitConfigError)
+	}
+}
+
+// setReportFormat validates the -report flag and routes progress output
+func setReportFormat(format string) {
+	switch format {
+	case "text":
+	case "json":
+		console = os.Stderr
+	default:
+		fatal(exitConfigError, "Invalid -report value %q (use text or json)", format)
+	}
+}
+
+// writeReport prints the JSON report on stdout when requested
+func writeReport(format string, report *sync.Report) {
+	if format != "json" {
+		return
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Printf("Failed to write report: %v", err)
+	}
+}
+
+// exitCode maps a sync report to the process exit code
+func exitCode(report *sync.Report) int {
+	switch {
+	case report.HasAuthFailure():
+		return exitAuthFailure
+	case report.HasFailures():
+		return exitPartialFailure
+	default:
+		return exitOK
+	}
+}
+
+// garminLoginExitCode maps a failed Garmin login to the process exit code.
+// Most failures come from the setup (credentials, Python), but a session that
+// needs garmin-login is an auth failure.
+func garminLoginExitCode(err error) int {
+	if errors.Is(err, garmin.ErrReauthRequired) {
+		return exitAuthFailure
+	}
+	return exitConfigError
+}
+
+// printSummary prints the totals of a report
+func printSummary(report *sync.Report) {
+	fmt.Fprintf(console, "  %d synced, %d skipped, %d failed\n", report.Synced, report.Skipped, report.Failed)
+}
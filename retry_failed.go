@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"garmin-to-ido/internal/config"
+	"garmin-to-ido/internal/ledger"
+	"garmin-to-ido/internal/sync"
+)
+
+// runRetryFailed replays failed uploads from the ledger's queue using the
+// archived FIT files and returns the exit code. Garmin is not contacted.
+func runRetryFailed(args []string) int {
+	var configPath, profile, idsFlag, reportFormat string
+	var debug, allProfiles bool
+	flags := flag.NewFlagSet("garmin-to-ido retry-failed", flag.ContinueOnError)
+	flags.StringVar(&configPath, "c", ".env", "Path to configuration file")
+	flags.StringVar(&configPath, "config", ".env", "Path to configuration file")
+	flags.StringVar(&profile, "profile", "", "Profile of the configuration file to retry")
+	flags.BoolVar(&allProfiles, "all-profiles", false, "Retry the failed uploads of every profile")
+	flags.StringVar(&idsFlag, "id", "", "Comma-separated Garmin activity IDs to retry (default: all failed uploads)")
+	flags.BoolVar(&debug, "debug", false, "Log request/response details for debugging purposes")
+	flags.StringVar(&reportFormat, "report", "text", "Report format: text, or json to print a machine-readable report on stdout")
+	parseFlags(flags, args)
+	setReportFormat(reportFormat)
+
+	ids, err := parseActivityIDs(idsFlag)
+	if err != nil {
+		fatal(exitConfigError, "Invalid -id value: %v", err)
+	}
+
+	code := exitOK
+	for _, cfg := range loadConfigs(configPath, profile, allProfiles) {
+		printProfile(cfg)
+		report, profileCode := retryProfile(cfg, ids, debug)
+		report.Profile = cfg.Profile
+		writeReport(reportFormat, report)
+		openNotifier(cfg).Send(report)
+		code = max(code, profileCode)
+	}
+	return code
+}
+
+// retryProfile replays the failed uploads of one profile and returns the
+// report and the exit code
+func retryProfile(cfg *config.Config, ids map[int64]bool, debug bool) (*sync.Report, int) {
+	syncLedger, err := ledger.Open(cfg.LedgerPath)
+	if err != nil {
+		err = fmt.Errorf("failed to open sync ledger: %w", err)
+		log.Printf("%v", err)
+		return sync.FailedReport(sync.ErrorConfig, err), exitConfigError
+	}
+
+	opts, err := syncOptions(cfg, syncLedger)
+	if err != nil {
+		log.Printf("%v", err)
+		return sync.FailedReport(sync.ErrorConfig, err), exitConfigError
+	}
+	opts.Output = console
+
+	// Nothing to replay, don't start the browser
+	if len(syncLedger.Failed()) == 0 {
+		report, _ := sync.NewSyncer(nil, nil, opts).RetryFailed(ids, debug)
+		return report, exitOK
+	}
+
+	destinations, err := openDestinations(cfg)
+	if err != nil {
+		log.Printf("%v", err)
+		return sync.FailedReport(sync.ErrorConfig, err), exitConfigError
+	}
+	defer closeDestinations(destinations)
+	if err := loginDestinations(destinations); err != nil {
+		log.Printf("%v", err)
+		return sync.FailedReport(sync.ErrorAuth, err), exitAuthFailure
+	}
+
+	syncer := sync.NewSyncer(nil, destinations, opts)
+	fmt.Fprintln(console, "\nRetrying failed uploads...")
+	report, err := syncer.RetryFailed(ids, debug)
+	if err != nil {
+		log.Printf("Error retrying failed uploads: %v", err)
+	}
+
+	fmt.Fprintln(console, "\n✓ Retry completed")
+	printSummary(report)
+	return report, exitCode(report)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	gosync "sync"
+	"syscall"
+	"time"
+
+	"garmin-to-ido/internal/api"
+	"garmin-to-ido/internal/config"
+	"garmin-to-ido/internal/daemon"
+	"garmin-to-ido/internal/garmin"
+	"garmin-to-ido/internal/ido"
+	"garmin-to-ido/internal/ledger"
+	"garmin-to-ido/internal/metrics"
+	"garmin-to-ido/internal/notify"
+	"garmin-to-ido/internal/sync"
+)
+
+// apiShutdownTimeout bounds the wait for the control API requests running at
+// shutdown
+const apiShutdownTimeout = time.Minute
+
+// runServe keeps the Garmin and destination sessions open and syncs on a
+// schedule, and returns the exit code once stopped. With several profiles,
+// each one runs its own daemon.
+func runServe(args []string) int {
+	var configPath, profile string
+	var debug, allProfiles bool
+	flags := flag.NewFlagSet("garmin-to-ido serve", flag.ContinueOnError)
+	flags.StringVar(&configPath, "c", ".env", "Path to configuration file")
+	flags.StringVar(&configPath, "config", ".env", "Path to configuration file")
+	flags.StringVar(&profile, "profile", "", "Profile of the configuration file to serve")
+	flags.BoolVar(&allProfiles, "all-profiles", false, "Serve every profile of the configuration file")
+	flags.BoolVar(&debug, "debug", false, "Log request/response details for debugging purposes")
+	parseFlags(flags, args)
+
+	cfgs := loadConfigs(configPath, profile, allProfiles)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// METRICS_ADDR is a shared setting, all profiles are exposed on one endpoint
+	var metricsSet *metrics.Set
+	if addr := cfgs[0].MetricsAddr; addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Printf("Failed to start metrics endpoint: %v", err)
+			return exitConfigError
+		}
+		metricsSet = metrics.NewSet()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsSet.Registry)
+		server := serveHTTP(listener, mux)
+		defer server.Close()
+		log.Printf("Serving metrics on http://%s/metrics", listener.Addr())
+	}
+
+	// API_ADDR and API_TOKEN are shared settings too. The port is opened
+	// before any session, the API is served once the daemons are started.
+	var apiListener net.Listener
+	if addr := cfgs[0].APIAddr; addr != "" {
+		var err error
+		if apiListener, err = net.Listen("tcp", addr); err != nil {
+			log.Printf("Failed to start control API: %v", err)
+			return exitConfigError
+		}
+		defer apiListener.Close()
+	}
+	var apiProfiles []api.Profile
+
+	// Profiles share stdout, so their progress lines are prefixed
+	var outputMu gosync.Mutex
+	var wg gosync.WaitGroup
+	// startCode is the worst exit code of the profiles that did not start
+	startCode := exitOK
+	for _, cfg := range cfgs {
+		var out io.Writer = os.Stdout
+		if cfg.Profile != "" {
+			out = &prefixWriter{mu: &outputMu, w: os.Stdout, prefix: []byte("[" + cfg.Profile + "] ")}
+		}
+
+		served, code, err := startDaemon(cfg, out, metricsSet.Profile(cfg.Profile), debug)
+		if err != nil {
+			if len(cfgs) == 1 {
+				log.Printf("%v", err)
+				return code
+			}
+			log.Printf("[%s] Not started: %v", cfg.Profile, err)
+			startCode = max(startCode, code)
+			continue
+		}
+		apiProfiles = append(apiProfiles, api.Profile{Name: cfg.Profile, Runner: served.daemon, Ledger: served.ledger})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer served.daemon.Close(served.cleanup)
+			if err := served.daemon.Run(ctx); err != nil {
+				log.Printf("Daemon stopped: %v", err)
+			}
+		}()
+	}
+
+	if len(apiProfiles) == 0 {
+		log.Printf("No profile could be started")
+		return startCode
+	}
+
+	apiStopped := make(chan struct{})
+	if apiListener != nil {
+		server := serveHTTP(apiListener, api.New(cfgs[0].APIToken, apiProfiles))
+		log.Printf("Serving control API on http://%s/api/", apiListener.Addr())
+		// No new requests once stopping. The runs they started finish before
+		// the daemons release their sessions, and their reports are answered.
+		go func() {
+			defer close(apiStopped)
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Control API did not stop in time: %v", err)
+			}
+		}()
+	} else {
+		close(apiStopped)
+	}
+	wg.Wait()
+	<-apiStopped
+	return startCode
+}
+
+// servedProfile is a started daemon, with what the control API needs
+type servedProfile struct {
+	daemon *daemon.Daemon
+	ledger *ledger.Ledger
+	// cleanup releases the sessions once the daemon has stopped, see
+	// daemon.Close
+	cleanup func()
+}
+
+// startDaemon logs one profile in and creates its daemon. On failure, the
+// exit code tells what went wrong.
+func startDaemon(cfg *config.Config, out io.Writer, recorder *metrics.Recorder, debug bool) (*servedProfile, int, error) {
+	quietHours, err := daemon.ParseQuietHours(cfg.QuietHours)
+	if err != nil {
+		return nil, exitConfigError, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		return nil, exitConfigError, fmt.Errorf("invalid notification settings: %w", err)
+	}
+
+	syncLedger, err := ledger.Open(cfg.LedgerPath)
+	if err != nil {
+		return nil, exitConfigError, fmt.Errorf("failed to open sync ledger: %w", err)
+	}
+	opts, err := syncOptions(cfg, syncLedger)
+	if err != nil {
+		return nil, exitConfigError, err
+	}
+
+	garminClient, err := newGarminClient(cfg, nil)
+	if err != nil {
+		return nil, exitConfigError, fmt.Errorf("failed to initialize Garmin client: %w", err)
+	}
+	// A session only garmin-login can restore does not stop the daemon: its
+	// polls report and notify the failure until the login succeeds
+	garminLoggedOut := false
+	if err := garminClient.Login(); err != nil {
+		garminClient.Logout()
+		if !errors.Is(err, garmin.ErrReauthRequired) {
+			return nil, garminLoginExitCode(err), fmt.Errorf("failed to initialize Garmin client: %w", err)
+		}
+		log.Printf("Garmin Connect needs garmin-login, the daemon starts anyway: %v", err)
+		garminLoggedOut = true
+	} else {
+		fmt.Fprintln(console, "✓ Initialized Garmin Connect client")
+	}
+
+	destinations, err := openDestinations(cfg)
+	if err != nil {
+		garminClient.Logout()
+		return nil, exitConfigError, err
+	}
+	if err := loginDestinations(destinations); err != nil {
+		closeDestinations(destinations)
+		garminClient.Logout()
+		return nil, exitAuthFailure, err
+	}
+
+	if session, ok := garminClient.(metrics.Session); ok {
+		recorder.TrackSession(metrics.ServiceGarmin, session)
+	}
+	for _, dest := range destinations {
+		if idoClient, ok := dest.(*ido.Client); ok {
+			idoClient.SetMetrics(recorder)
+			recorder.TrackSession(metrics.ServiceIDO, idoClient)
+		}
+	}
+
+	opts.Output = out
+	opts.Metrics = recorder
+	syncer := sync.NewSyncer(garminClient, destinations, opts)
+
+	d := daemon.New(garminClient, destinations, syncer, daemon.Options{
+		Interval:        cfg.PollInterval,
+		LookbackDays:    cfg.PollLookbackDays,
+		QuietHours:      quietHours,
+		Location:        cfg.Timezone,
+		Notifier:        notifier,
+		Metrics:         recorder,
+		Debug:           debug,
+		Name:            cfg.Profile,
+		GarminLoggedOut: garminLoggedOut,
+	})
+	cleanup := func() {
+		closeDestinations(destinations)
+		garminClient.Logout()
+	}
+	return &servedProfile{daemon: d, ledger: syncLedger, cleanup: cleanup}, exitOK, nil
+}
+
+// serveHTTP serves handler on listener in the background
+func serveHTTP(listener net.Listener, handler http.Handler) *http.Server {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server on %s stopped: %v", listener.Addr(), err)
+		}
+	}()
+	return server
+}
+
+// prefixWriter prefixes every line written to w. Writers sharing mu don't
+// interleave their lines.
+type prefixWriter struct {
+	mu      *gosync.Mutex
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !p.midLine {
+			buf.Write(p.prefix)
+		}
+		buf.Write(line)
+		p.midLine = line[len(line)-1] != '\n'
+	}
+
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
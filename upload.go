@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"garmin-to-ido/internal/ledger"
+	"garmin-to-ido/internal/sync"
+)
+
+// runUpload uploads local FIT files to the destinations and returns the exit
+// code. Garmin is not contacted.
+func runUpload(args []string) int {
+	var configPath, profile, reportFormat string
+	var debug, force bool
+	flags := flag.NewFlagSet("garmin-to-ido upload", flag.ContinueOnError)
+	flags.StringVar(&configPath, "c", ".env", "Path to configuration file")
+	flags.StringVar(&configPath, "config", ".env", "Path to configuration file")
+	flags.StringVar(&profile, "profile", "", "Profile of the configuration file the files are uploaded for")
+	flags.BoolVar(&debug, "debug", false, "Log request/response details for debugging purposes")
+	flags.BoolVar(&force, "force", false, "Upload the files even if already synced")
+	flags.StringVar(&reportFormat, "report", "text", "Report format: text, or json to print a machine-readable report on stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage:\n  garmin-to-ido upload [flags] <file.fit|directory>...\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	setReportFormat(reportFormat)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitConfigError
+	}
+
+	cfg := loadConfig(configPath, profile)
+
+	syncLedger, err := ledger.Open(cfg.LedgerPath)
+	if err != nil {
+		fatal(exitConfigError, "Failed to open sync ledger: %v", err)
+	}
+
+	opts, err := syncOptions(cfg, syncLedger)
+	if err != nil {
+		fatal(exitConfigError, "%v", err)
+	}
+	opts.Output = console
+
+	destinations, err := openDestinations(cfg)
+	if err != nil {
+		fatal(exitConfigError, "%v", err)
+	}
+	defer closeDestinations(destinations)
+	if err := loginDestinations(destinations); err != nil {
+		log.Printf("%v", err)
+		return exitAuthFailure
+	}
+	syncer := sync.NewSyncer(nil, destinations, opts)
+	fmt.Fprintln(console, "\nUploading local FIT files...")
+	report, err := syncer.UploadFiles(flags.Args(), force, debug)
+	if err != nil {
+		log.Printf("Error uploading files: %v", err)
+	}
+	writeReport(reportFormat, report)
+
+	fmt.Fprintln(console, "\n✓ Upload completed")
+	printSummary(report)
+	return exitCode(report)
+}